@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aerokube/selenoid/info"
+	"github.com/aerokube/selenoid/jsonerror"
+	"github.com/aerokube/selenoid/session"
+	"github.com/aerokube/selenoid/upload"
+)
+
+var adminPaths = struct {
+	Sessions, Drain, Uploads string
+}{
+	Sessions: paths.Admin + "sessions",
+	Drain:    paths.Admin + "drain",
+	Uploads:  paths.Admin + "uploads",
+}
+
+// adminSession - session information returned by admin API
+type adminSession struct {
+	ID        string             `json:"id"`
+	Quota     string             `json:"quota"`
+	Caps      session.Caps       `json:"caps"`
+	Container *session.Container `json:"container,omitempty"`
+	HostPort  session.HostPort   `json:"hostPort"`
+	Timeout   string             `json:"timeout"`
+	Idle      string             `json:"idle"`
+	Started   time.Time          `json:"started"`
+}
+
+func newAdminSession(id string, sess *session.Session) adminSession {
+	return adminSession{
+		ID:        id,
+		Quota:     sess.Quota,
+		Caps:      sess.Caps,
+		Container: sess.Container,
+		HostPort:  sess.HostPort,
+		Timeout:   sess.Timeout.String(),
+		Idle:      sess.Idle().Round(time.Millisecond).String(),
+		Started:   sess.Started,
+	}
+}
+
+func admin() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminPaths.Sessions, adminListSessions)
+	mux.HandleFunc(adminPaths.Sessions+slash, adminSessionHandler)
+	mux.HandleFunc(adminPaths.Drain, adminDrain)
+	mux.HandleFunc(adminPaths.Uploads, adminUploads)
+	mux.HandleFunc(adminPaths.Uploads+slash, adminRetryUpload)
+	return adminAuth(mux)
+}
+
+func adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			user, remote := info.RequestInfo(r)
+			log.Printf("[-] [ADMIN_UNAUTHORIZED] [%s] [%s] [%s]", user, remote, r.URL.Path)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func adminListSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	quota, browser := query.Get("quota"), query.Get("browser")
+	labels := query["label"]
+	ret := []adminSession{}
+	sessions.Each(func(id string, sess *session.Session) {
+		if quota != "" && sess.Quota != quota {
+			return
+		}
+		if browser != "" && sess.Caps.BrowserName() != browser {
+			return
+		}
+		if !matchLabels(sess.Caps.Labels, labels) {
+			return
+		}
+		ret = append(ret, newAdminSession(id, sess))
+	})
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Started.Before(ret[j].Started)
+	})
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ret)
+}
+
+func adminSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, adminPaths.Sessions+slash)
+	sess, ok := sessions.Get(id)
+	if !ok {
+		jsonerror.InvalidSessionID(fmt.Errorf("unknown session %s", id)).Encode(w)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(newAdminSession(id, sess))
+	case http.MethodDelete:
+		requestId := serial()
+		user, remote := info.RequestInfo(r)
+		saveFinalState(requestId, id, sess)
+		sess.Lock.Lock()
+		if _, ok := sessions.Get(id); !ok {
+			sess.Lock.Unlock()
+			jsonerror.InvalidSessionID(fmt.Errorf("unknown session %s", id)).Encode(w)
+			return
+		}
+		cancel := removeSession(requestId, id, sess)
+		sess.Lock.Unlock()
+		cancel()
+		log.Printf("[%d] [SESSION_TERMINATED] [%s] [%s] [%s]", requestId, id, user, remote)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func adminDrain(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		setDraining(true)
+	case http.MethodDelete:
+		setDraining(false)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Draining bool `json:"draining"`
+		Sessions int  `json:"sessions"`
+	}{queue.Draining(), sessions.Len()})
+}
+
+func adminUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status, ok := upload.QueueStatus()
+	if !ok {
+		http.Error(w, "No uploaders configured", http.StatusNotFound)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+func adminRetryUpload(w http.ResponseWriter, r *http.Request) {
+	const retrySuffix = "/retry"
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, retrySuffix) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, adminPaths.Uploads+slash), retrySuffix)
+	if !upload.Retry(id) {
+		http.Error(w, fmt.Sprintf("Unknown upload %s", id), http.StatusNotFound)
+		return
+	}
+	user, remote := info.RequestInfo(r)
+	log.Printf("[-] [RETRYING_UPLOAD] [%s] [%s] [%s]", id, user, remote)
+}
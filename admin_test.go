@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+const testAdminToken = "test-admin-token"
+
+func adminRequest(method string, path string) (*http.Response, error) {
+	req, _ := http.NewRequest(method, With(srv.URL).Path(path), nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	return http.DefaultClient.Do(req)
+}
+
+func TestAdminUnauthorized(t *testing.T) {
+	rsp, err := http.Get(With(srv.URL).Path("/admin/sessions"))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusUnauthorized)
+
+	for _, header := range []string{testAdminToken, "Basic " + testAdminToken, "Bearer wrong-token"} {
+		req, _ := http.NewRequest(http.MethodGet, With(srv.URL).Path("/admin/sessions"), nil)
+		req.Header.Set("Authorization", header)
+		rsp, err = http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, rsp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestAdminSessions(t *testing.T) {
+	ch := make(chan bool)
+	manager = &HTTPTest{
+		Handler: Selenium(),
+		Cancel:  ch,
+	}
+
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"desiredCapabilities": {"browserName": "firefox", "labels": {"team": "qa"}}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var sess map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sess))
+	sessionId := sess["sessionId"]
+
+	var list []adminSession
+	rsp, err := adminRequest(http.MethodGet, "/admin/sessions?browser=firefox&label=team=qa")
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&list))
+	assert.Len(t, list, 1)
+	assert.Equal(t, list[0].ID, sessionId)
+
+	rsp, err = adminRequest(http.MethodGet, "/admin/sessions?label=team=dev")
+	assert.NoError(t, err)
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&list))
+	assert.Empty(t, list)
+
+	var info adminSession
+	rsp, err = adminRequest(http.MethodGet, fmt.Sprintf("/admin/sessions/%s", sessionId))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&info))
+	assert.Equal(t, info.Caps.BrowserName(), "firefox")
+	assert.NotEmpty(t, info.HostPort.Fileserver)
+	assert.NotEmpty(t, info.Idle)
+
+	rsp, err = adminRequest(http.MethodDelete, fmt.Sprintf("/admin/sessions/%s", sessionId))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	assert.True(t, <-ch)
+	_, ok := sessions.Get(sessionId)
+	assert.False(t, ok)
+	assert.Equal(t, queue.Used(), 0)
+
+	rsp, err = adminRequest(http.MethodDelete, fmt.Sprintf("/admin/sessions/%s", sessionId))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusNotFound)
+}
+
+func TestAdminDrain(t *testing.T) {
+	manager = &HTTPTest{Handler: Selenium()}
+
+	rsp, err := adminRequest(http.MethodPost, "/admin/drain")
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	assert.True(t, queue.Draining())
+	defer queue.Drain(false)
+
+	rsp, err = http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte("{}")))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusTooManyRequests)
+	assert.Equal(t, queue.Used(), 0)
+
+	rsp, err = http.Get(With(srv.URL).Path("/wd/hub/status"))
+	assert.NoError(t, err)
+	var data map[string]map[string]interface{}
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&data))
+	assert.Equal(t, data["value"]["ready"], false)
+
+	rsp, err = adminRequest(http.MethodDelete, "/admin/drain")
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	assert.False(t, queue.Draining())
+}
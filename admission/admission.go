@@ -0,0 +1,125 @@
+package admission
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aerokube/selenoid/session"
+)
+
+// Request - new session request sent to admission hook
+type Request struct {
+	RequestId    uint64       `json:"requestId"`
+	Quota        string       `json:"quota"`
+	Remote       string       `json:"remote"`
+	Capabilities session.Caps `json:"capabilities"`
+}
+
+// Response - admission hook decision
+type Response struct {
+	Allowed      bool              `json:"allowed"`
+	Message      string            `json:"message,omitempty"`
+	Capabilities json.RawMessage   `json:"capabilities,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+// Rejected - returned when admission hook vetoes new session
+type Rejected struct {
+	Message string
+}
+
+func (r *Rejected) Error() string {
+	if r.Message == "" {
+		return "rejected by admission hook"
+	}
+	return fmt.Sprintf("rejected by admission hook: %s", r.Message)
+}
+
+// Hook - HTTP admission hook called before starting a session
+type Hook struct {
+	URL      string
+	Timeout  time.Duration
+	FailOpen bool
+
+	client *http.Client
+}
+
+// New creates admission hook, an empty URL disables it
+func New(url string, timeout time.Duration, failOpen bool) *Hook {
+	return &Hook{URL: url, Timeout: timeout, FailOpen: failOpen, client: &http.Client{}}
+}
+
+// Enabled - whether hook URL is set
+func (h *Hook) Enabled() bool {
+	return h != nil && h.URL != ""
+}
+
+// Review - ask admission hook whether session can be created. Capabilities are patched in place.
+func (h *Hook) Review(ctx context.Context, requestId uint64, quota string, remote string, caps *session.Caps) error {
+	if !h.Enabled() {
+		return nil
+	}
+	resp, err := h.call(ctx, &Request{RequestId: requestId, Quota: quota, Remote: remote, Capabilities: *caps})
+	if err != nil {
+		if h.FailOpen {
+			log.Printf("[%d] [ADMISSION_HOOK_FAILED] [%s] [Allowing: %v]", requestId, h.URL, err)
+			return nil
+		}
+		return fmt.Errorf("admission hook: %v", err)
+	}
+	if !resp.Allowed {
+		return &Rejected{Message: resp.Message}
+	}
+	if len(resp.Capabilities) > 0 && string(resp.Capabilities) != "null" {
+		if err := caps.Patch(resp.Capabilities); err != nil {
+			return fmt.Errorf("admission hook: patch capabilities: %v", err)
+		}
+	}
+	if len(resp.Labels) > 0 {
+		if caps.Labels == nil {
+			caps.Labels = make(map[string]string)
+		}
+		for k, v := range resp.Labels {
+			caps.Labels[k] = v
+		}
+	}
+	return nil
+}
+
+func (h *Hook) call(ctx context.Context, input *Request) (*Response, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %v", err)
+	}
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 1024))
+		return nil, fmt.Errorf("%s: %s", rsp.Status, bytes.TrimSpace(msg))
+	}
+	var ret Response
+	err = json.NewDecoder(rsp.Body).Decode(&ret)
+	if err != nil {
+		return nil, fmt.Errorf("parse response: %v", err)
+	}
+	return &ret, nil
+}
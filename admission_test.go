@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aerokube/selenoid/admission"
+	"github.com/aerokube/selenoid/policy"
+	"github.com/aerokube/selenoid/session"
+	assert "github.com/stretchr/testify/require"
+)
+
+func admissionMux(fn func(req admission.Request) admission.Response) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		var req admission.Request
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(fn(req))
+	})
+	return mux
+}
+
+func TestAdmissionDisabled(t *testing.T) {
+	hook := admission.New("", time.Second, false)
+	caps := session.Caps{Name: "firefox"}
+	assert.NoError(t, hook.Review(context.Background(), 1, "some-user", "127.0.0.1", &caps))
+}
+
+func TestAdmissionApproved(t *testing.T) {
+	stub := httptest.NewServer(admissionMux(func(req admission.Request) admission.Response {
+		assert.Equal(t, req.Quota, "some-user")
+		assert.Equal(t, req.Remote, "127.0.0.1")
+		assert.Equal(t, req.Capabilities.BrowserName(), "firefox")
+		return admission.Response{
+			Allowed:      true,
+			Capabilities: json.RawMessage(`{"enableVideo": true}`),
+			Labels:       map[string]string{"cost-center": "qa"},
+		}
+	}))
+	defer stub.Close()
+	hook := admission.New(stub.URL, time.Second, false)
+	caps := session.Caps{Name: "firefox", Labels: map[string]string{"key": "value"}}
+	assert.NoError(t, hook.Review(context.Background(), 1, "some-user", "127.0.0.1", &caps))
+	assert.True(t, caps.Video)
+	assert.Equal(t, caps.Labels, map[string]string{"key": "value", "cost-center": "qa"})
+}
+
+func TestAdmissionRejected(t *testing.T) {
+	stub := httptest.NewServer(admissionMux(func(req admission.Request) admission.Response {
+		return admission.Response{Message: "invalid CI token"}
+	}))
+	defer stub.Close()
+	hook := admission.New(stub.URL, time.Second, true)
+	caps := session.Caps{Name: "firefox"}
+	err := hook.Review(context.Background(), 1, "some-user", "127.0.0.1", &caps)
+	assert.Error(t, err)
+	assert.Equal(t, err.Error(), "rejected by admission hook: invalid CI token")
+}
+
+func TestAdmissionFailOpenAndClosed(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-time.After(100 * time.Millisecond)
+	}))
+	defer stub.Close()
+	caps := session.Caps{Name: "firefox"}
+
+	hook := admission.New(stub.URL, 10*time.Millisecond, true)
+	assert.NoError(t, hook.Review(context.Background(), 1, "some-user", "127.0.0.1", &caps))
+
+	hook = admission.New(stub.URL, 10*time.Millisecond, false)
+	assert.Error(t, hook.Review(context.Background(), 1, "some-user", "127.0.0.1", &caps))
+}
+
+func TestSessionRejectedByAdmissionHook(t *testing.T) {
+	stub := httptest.NewServer(admissionMux(func(req admission.Request) admission.Response {
+		return admission.Response{Message: "not today"}
+	}))
+	defer stub.Close()
+	admissionHook = admission.New(stub.URL, time.Second, false)
+	defer func() {
+		admissionHook = admission.New("", 0, false)
+	}()
+	manager = &HTTPTest{Handler: Selenium()}
+
+	rsp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"desiredCapabilities": {"browserName": "firefox"}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusInternalServerError)
+	var data map[string]map[string]string
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&data))
+	assert.Equal(t, data["value"]["message"], "rejected by admission hook: not today")
+	assert.Equal(t, queue.Used(), 0)
+}
+
+func TestAdmissionHookPatchViolatingPolicy(t *testing.T) {
+	calls := 0
+	stub := httptest.NewServer(admissionMux(func(req admission.Request) admission.Response {
+		calls++
+		return admission.Response{Allowed: true, Capabilities: json.RawMessage(`{"env": ["A=B"]}`)}
+	}))
+	defer stub.Close()
+	admissionHook = admission.New(stub.URL, time.Second, false)
+	policies = loadTestPolicies(t, testPolicies)
+	defer func() {
+		admissionHook = admission.New("", 0, false)
+		policies = policy.New()
+	}()
+	manager = &HTTPTest{Handler: Selenium()}
+
+	rsp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"capabilities":{"alwaysMatch":{"browserName": "firefox"}, "firstMatch": [{"browserVersion": "57.0"}, {"browserVersion": "58.0"}]}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusBadRequest)
+	assert.Equal(t, calls, 1)
+	assert.Equal(t, queue.Used(), 0)
+}
+
+func TestAdmissionHookDisablesCapabilities(t *testing.T) {
+	stub := httptest.NewServer(admissionMux(func(req admission.Request) admission.Response {
+		assert.True(t, req.Capabilities.Video)
+		assert.True(t, req.Capabilities.VNC)
+		return admission.Response{Allowed: true, Capabilities: json.RawMessage(`{"enableVideo": false, "enableVNC": false, "name": ""}`)}
+	}))
+	defer stub.Close()
+	admissionHook = admission.New(stub.URL, time.Second, false)
+	defer func() {
+		admissionHook = admission.New("", 0, false)
+	}()
+	manager = &HTTPTest{Handler: Selenium()}
+
+	rsp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"desiredCapabilities": {"browserName": "firefox", "enableVideo": true, "enableVNC": true, "name": "LoginTest"}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	var data map[string]string
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&data))
+	sess, ok := sessions.Get(data["sessionId"])
+	assert.True(t, ok)
+	defer func() {
+		sessions.Remove(data["sessionId"])
+		queue.Release()
+	}()
+	assert.False(t, sess.Caps.Video)
+	assert.False(t, sess.Caps.VNC)
+	assert.Empty(t, sess.Caps.TestName)
+	assert.Equal(t, sess.Caps.BrowserName(), "firefox")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aerokube/selenoid/upload"
+	assert "github.com/stretchr/testify/require"
+)
+
+func testArchiveUploader(t *testing.T, keepFiles bool) (*upload.ArchiveUploader, string) {
+	dir, err := os.MkdirTemp("", "selenoid-archive")
+	assert.NoError(t, err)
+	uploader := &upload.ArchiveUploader{
+		Dir:          dir,
+		PathPattern:  "$quota/$browserName/$sessionId/$fileType$fileExtension",
+		KeepFiles:    keepFiles,
+		ExcludeFiles: "*.log",
+	}
+	uploader.Init()
+	return uploader, dir
+}
+
+func TestArchiveUploader(t *testing.T) {
+	uploader, dir := testArchiveUploader(t, false)
+	defer os.RemoveAll(dir)
+
+	f, _ := os.CreateTemp("", "some-file*.mp4")
+	_, _ = f.WriteString("test-data")
+	_ = f.Close()
+	defer os.Remove(f.Name())
+
+	uploaded, err := uploader.Upload(testCreatedFile(f.Name()))
+	assert.NoError(t, err)
+	assert.True(t, uploaded)
+	assert.False(t, uploader.KeepsFiles())
+	// Source file is removed by upload queue when all uploads succeed
+	_, err = os.Stat(f.Name())
+	assert.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(dir, "some-user", "firefox", "some-session-id", "video.mp4"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(data), "test-data")
+}
+
+func TestArchiveUploaderCollision(t *testing.T) {
+	uploader, dir := testArchiveUploader(t, true)
+	defer os.RemoveAll(dir)
+
+	f, _ := os.CreateTemp("", "some-file*.mp4")
+	_ = f.Close()
+	defer os.Remove(f.Name())
+
+	for i := 0; i < 2; i++ {
+		uploaded, err := uploader.Upload(testCreatedFile(f.Name()))
+		assert.NoError(t, err)
+		assert.True(t, uploaded)
+	}
+	_, err := os.Stat(f.Name())
+	assert.NoError(t, err)
+	files, err := os.ReadDir(filepath.Join(dir, "some-user", "firefox", "some-session-id"))
+	assert.NoError(t, err)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	assert.Equal(t, names, []string{"video-1.mp4", "video.mp4"})
+}
+
+func TestArchiveUploaderExcludedAndUnsafe(t *testing.T) {
+	uploader, dir := testArchiveUploader(t, true)
+	defer os.RemoveAll(dir)
+
+	uploaded, err := uploader.Upload(testCreatedFile("/path/to/session.log"))
+	assert.NoError(t, err)
+	assert.False(t, uploaded)
+
+	f, _ := os.CreateTemp("", "some-file*.mp4")
+	_ = f.Close()
+	defer os.Remove(f.Name())
+	input := testCreatedFile(f.Name())
+	input.Session.Quota = "../../.."
+	uploader.PathPattern = "$quota/$date/$fileName"
+	uploaded, err = uploader.Upload(input)
+	assert.NoError(t, err)
+	assert.True(t, uploaded)
+	_, err = os.Stat(filepath.Join(dir, time.Now().Format("2006-01-02"), filepath.Base(f.Name())))
+	assert.NoError(t, err)
+}
+
+func TestArchiveUploaderLink(t *testing.T) {
+	uploader, dir := testArchiveUploader(t, false)
+	defer os.RemoveAll(dir)
+
+	f, _ := os.CreateTemp("", "some-file*.mp4")
+	_ = f.Close()
+
+	location, uploaded, err := uploader.UploadTo(testCreatedFile(f.Name()))
+	assert.NoError(t, err)
+	assert.True(t, uploaded)
+	link, ok, err := uploader.Link(location, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, link, "file://"+filepath.ToSlash(filepath.Join(dir, "some-user", "firefox", "some-session-id", "video.mp4")))
+
+	_, ok, _ = uploader.Link("file:///etc/passwd", time.Hour)
+	assert.False(t, ok)
+	_, ok, _ = uploader.Link("s3://bucket/video.mp4", time.Hour)
+	assert.False(t, ok)
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+	"github.com/aerokube/selenoid/session"
+	"github.com/aerokube/selenoid/upload"
+)
+
+const (
+	detailsParam = "details"
+	totalHeader  = "X-Total-Count"
+	// Files of older sessions are found by saved session metadata and default file names
+	artifactIndexSessions = upload.CatalogSessions
+)
+
+var artifacts = newArtifactIndex()
+
+func init() {
+	event.AddFileCreatedListener(artifacts)
+	event.AddFileDeletedListener(artifacts)
+}
+
+// artifactSession - information about session that produced a file
+type artifactSession struct {
+	SessionId string            `json:"sessionId,omitempty"`
+	TestName  string            `json:"testName,omitempty"`
+	Quota     string            `json:"quota,omitempty"`
+	Browser   string            `json:"browser,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+func newArtifactSession(sessionId string, quota string, caps session.Caps) artifactSession {
+	return artifactSession{
+		SessionId: sessionId,
+		TestName:  caps.TestName,
+		Quota:     quota,
+		Browser:   caps.BrowserName(),
+		Version:   caps.Version,
+		Labels:    caps.Labels,
+	}
+}
+
+// artifactIndex - remembers sessions that produced files by file name and file names with types by session,
+// only files of limited number of the latest sessions are remembered
+type artifactIndex struct {
+	lock      sync.RWMutex
+	limit     int
+	files     map[string]artifactSession
+	bySession map[string]map[string]string
+	// order - session IDs from the oldest to the latest one
+	order    *list.List
+	sessions map[string]*list.Element
+	// saved - sessions read from saved metadata with the same limit, the oldest read session is evicted first
+	saved      map[string]artifactSession
+	savedOrder *list.List
+}
+
+func newArtifactIndex() *artifactIndex {
+	return &artifactIndex{
+		limit:      artifactIndexSessions,
+		files:      make(map[string]artifactSession),
+		bySession:  make(map[string]map[string]string),
+		order:      list.New(),
+		sessions:   make(map[string]*list.Element),
+		saved:      make(map[string]artifactSession),
+		savedOrder: list.New(),
+	}
+}
+
+func (ai *artifactIndex) OnFileCreated(createdFile event.CreatedFile) {
+	sess := createdFile.Session
+	name := filepath.Base(createdFile.Name)
+	ai.lock.Lock()
+	defer ai.lock.Unlock()
+	if as, ok := ai.files[name]; ok && as.SessionId != createdFile.SessionId {
+		ai.remove(name)
+	}
+	ai.files[name] = newArtifactSession(createdFile.SessionId, sess.Quota, sess.Caps)
+	if _, ok := ai.bySession[createdFile.SessionId]; !ok {
+		ai.bySession[createdFile.SessionId] = make(map[string]string)
+		ai.sessions[createdFile.SessionId] = ai.order.PushBack(createdFile.SessionId)
+	}
+	ai.bySession[createdFile.SessionId][name] = createdFile.Type
+	for ai.order.Len() > ai.limit {
+		oldest := ai.order.Front().Value.(string)
+		for name := range ai.bySession[oldest] {
+			ai.remove(name)
+		}
+	}
+}
+
+func (ai *artifactIndex) OnFileDeleted(deletedFile event.DeletedFile) {
+	name := filepath.Base(deletedFile.Name)
+	// Uploaded copies are still available
+	if !upload.Uploaded(name) {
+		ai.forget(name)
+	}
+}
+
+func (ai *artifactIndex) forget(name string) {
+	ai.lock.Lock()
+	defer ai.lock.Unlock()
+	ai.remove(name)
+}
+
+// remove - forgets file and its session when it has no more files, has to be called with lock held
+func (ai *artifactIndex) remove(name string) {
+	if as, ok := ai.files[name]; ok {
+		delete(ai.bySession[as.SessionId], name)
+		if len(ai.bySession[as.SessionId]) == 0 {
+			delete(ai.bySession, as.SessionId)
+			ai.order.Remove(ai.sessions[as.SessionId])
+			delete(ai.sessions, as.SessionId)
+		}
+	}
+	delete(ai.files, name)
+}
+
+// names - file names with types produced by session
+func (ai *artifactIndex) names(sessionId string) map[string]string {
+	ai.lock.RLock()
+	defer ai.lock.RUnlock()
+	ret := make(map[string]string)
+	for name, fileType := range ai.bySession[sessionId] {
+		ret[name] = fileType
+	}
+	return ret
+}
+
+// get - session that produced a file, falls back to saved session metadata when file was created before restart
+func (ai *artifactIndex) get(name string, sessionId string) (artifactSession, bool) {
+	return ai.lookup(name, sessionId, nil)
+}
+
+// describe - fill in sessions that produced listed files, saved metadata of every session is read at most once
+func (ai *artifactIndex) describe(files []*listedFile) {
+	missing := make(map[string]bool)
+	for _, lf := range files {
+		if as, ok := ai.lookup(lf.Name, lf.SessionId, missing); ok {
+			lf.artifactSession = as
+		}
+	}
+}
+
+// lookup - same as get, remembers session IDs without saved metadata in missing map when it is not nil
+func (ai *artifactIndex) lookup(name string, sessionId string, missing map[string]bool) (artifactSession, bool) {
+	if sessionId == "" {
+		sessionId = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	ai.lock.RLock()
+	as, ok := ai.files[name]
+	if !ok {
+		as, ok = ai.saved[sessionId]
+	}
+	ai.lock.RUnlock()
+	if ok {
+		return as, true
+	}
+	if missing[sessionId] {
+		return artifactSession{}, false
+	}
+	meta, ok := readMetadata(sessionId)
+	if !ok {
+		if missing != nil {
+			missing[sessionId] = true
+		}
+		return artifactSession{}, false
+	}
+	as = newArtifactSession(meta.ID, "", meta.Capabilities)
+	ai.lock.Lock()
+	defer ai.lock.Unlock()
+	if _, ok := ai.saved[sessionId]; !ok {
+		ai.savedOrder.PushBack(sessionId)
+	}
+	ai.saved[sessionId] = as
+	for ai.savedOrder.Len() > ai.limit {
+		delete(ai.saved, ai.savedOrder.Remove(ai.savedOrder.Front()).(string))
+	}
+	return as, true
+}
+
+// readMetadata - session metadata saved to log output directory
+func readMetadata(sessionId string) (session.Metadata, bool) {
+	var meta session.Metadata
+	if logOutputDir == "" || sessionId == "" {
+		return meta, false
+	}
+	data, err := os.ReadFile(filepath.Join(logOutputDir, filepath.Base(sessionId)+metadataFileExtension))
+	if err != nil {
+		return meta, false
+	}
+	if err := json.Unmarshal(data, &meta); err != nil || meta.ID == "" {
+		return meta, false
+	}
+	return meta, true
+}
+
+// listedFile - file in local directory or uploaded copies of deleted file
+type listedFile struct {
+	Name      string     `json:"name"`
+	Type      string     `json:"type,omitempty"`
+	Local     bool       `json:"local"`
+	Size      int64      `json:"size,omitempty"`
+	Modified  *time.Time `json:"modified,omitempty"`
+	Locations []string   `json:"locations,omitempty"`
+	artifactSession
+}
+
+// listFiles - local and uploaded files of given type, producing sessions are filled in only when needed by artifacts.describe
+func listFiles(dir string, fileType string) ([]*listedFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var listed []*listedFile
+	byName := make(map[string]*listedFile)
+	for _, e := range entries {
+		lf := &listedFile{Name: e.Name(), Type: fileTypeOf(e.Name()), Local: true}
+		if fi, err := e.Info(); err == nil {
+			modified := fi.ModTime()
+			lf.Size, lf.Modified = fi.Size(), &modified
+		}
+		listed = append(listed, lf)
+		byName[e.Name()] = lf
+	}
+	for _, a := range upload.Artifacts(fileType) {
+		lf, ok := byName[a.Name]
+		if !ok {
+			uploaded := a.Uploaded
+			lf = &listedFile{Name: a.Name, Type: a.Type, Modified: &uploaded}
+			lf.SessionId = a.SessionId
+			listed = append(listed, lf)
+			byName[a.Name] = lf
+		}
+		lf.Locations = append(lf.Locations, a.Location)
+	}
+	sort.Slice(listed, func(i, j int) bool {
+		return listed[i].Name < listed[j].Name
+	})
+	return listed, nil
+}
+
+func fileTypeOf(name string) string {
+	if strings.HasSuffix(name, commandLogFileExtension) {
+		return commandLogFileType
+	}
+	if strings.HasSuffix(name, consoleLogFileExtension) {
+		return consoleLogFileType
+	}
+	if strings.HasSuffix(name, vncRecordingFileExtension) {
+		return vncRecordingFileType
+	}
+	switch filepath.Ext(name) {
+	case videoFileExtension:
+		return videoFileType
+	case logFileExtension:
+		return logFileType
+	case metadataFileExtension:
+		return metadataFileType
+	case screenshotFileExtension:
+		return screenshotFileType
+	case pageSourceFileExtension:
+		return pageSourceFileType
+	case subtitlesFileExtension:
+		return subtitlesFileType
+	case harFileExtension:
+		return harFileType
+	}
+	return ""
+}
+
+// filterFiles - apply filtering, sorting and pagination query parameters, returns total number of matched files
+func filterFiles(files []*listedFile, query url.Values) ([]*listedFile, int, error) {
+	var since, until time.Time
+	for param, t := range map[string]*time.Time{"since": &since, "until": &until} {
+		if v := query.Get(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid %s: %v", param, err)
+			}
+			*t = parsed
+		}
+	}
+	matches := func(param string, value string) bool {
+		expected := query.Get(param)
+		return expected == "" || expected == value
+	}
+	var ret []*listedFile
+	for _, f := range files {
+		if !matches("type", f.Type) || !matches("sessionId", f.SessionId) || !matches("testName", f.TestName) ||
+			!matches("quota", f.Quota) || !matches("browser", f.Browser) || !matches("version", f.Version) {
+			continue
+		}
+		if query.Has("local") && strconv.FormatBool(f.Local) != query.Get("local") {
+			continue
+		}
+		if !matchLabels(f.Labels, query["label"]) {
+			continue
+		}
+		if f.Modified != nil && ((!since.IsZero() && f.Modified.Before(since)) || (!until.IsZero() && f.Modified.After(until))) {
+			continue
+		}
+		ret = append(ret, f)
+	}
+	err := sortFiles(ret, query.Get("sort"))
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(ret)
+	offset, err := intParam(query, "offset")
+	if err != nil {
+		return nil, 0, err
+	}
+	limit, err := intParam(query, "limit")
+	if err != nil {
+		return nil, 0, err
+	}
+	ret = ret[min(offset, total):]
+	if limit > 0 && limit < len(ret) {
+		ret = ret[:limit]
+	}
+	return ret, total, nil
+}
+
+func sortFiles(files []*listedFile, by string) error {
+	desc := strings.HasPrefix(by, "-")
+	var less func(a, b *listedFile) bool
+	switch strings.TrimPrefix(by, "-") {
+	case "", "name":
+		less = func(a, b *listedFile) bool { return a.Name < b.Name }
+	case "size":
+		less = func(a, b *listedFile) bool { return a.Size < b.Size }
+	case "modified":
+		less = func(a, b *listedFile) bool {
+			return a.Modified != nil && (b.Modified == nil || a.Modified.Before(*b.Modified))
+		}
+	default:
+		return fmt.Errorf("unsupported sort field %s", by)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if desc {
+			return less(files[j], files[i])
+		}
+		return less(files[i], files[j])
+	})
+	return nil
+}
+
+func intParam(query url.Values, param string) (int, error) {
+	v := query.Get(param)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %s", param, v)
+	}
+	return n, nil
+}
+
+func matchLabels(labels map[string]string, filters []string) bool {
+	for _, l := range filters {
+		k, v, hasValue := strings.Cut(l, "=")
+		lv, ok := labels[k]
+		if !ok || (hasValue && lv != v) {
+			return false
+		}
+	}
+	return true
+}
+
+func writeFileList(w http.ResponseWriter, r *http.Request, files []*listedFile) {
+	w.Header().Add("Content-Type", "application/json")
+	query := r.URL.Query()
+	if !query.Has(detailsParam) && !query.Has(locationsParam) {
+		var ret []string
+		for _, f := range files {
+			ret = append(ret, f.Name)
+		}
+		_ = json.NewEncoder(w).Encode(ret)
+		return
+	}
+	artifacts.describe(files)
+	filtered, total, err := filterFiles(files, query)
+	if err != nil {
+		w.Header().Del("Content-Type")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set(totalHeader, strconv.Itoa(total))
+	if filtered == nil {
+		filtered = []*listedFile{}
+	}
+	_ = json.NewEncoder(w).Encode(filtered)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+	"github.com/aerokube/selenoid/session"
+	assert "github.com/stretchr/testify/require"
+)
+
+func testListedFiles() []*listedFile {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	return []*listedFile{
+		{Name: "a.mp4", Type: videoFileType, Local: true, Size: 300, Modified: at(-3 * time.Hour),
+			artifactSession: artifactSession{SessionId: "a", Quota: "alice", Browser: "chrome", Labels: map[string]string{"team": "checkout"}}},
+		{Name: "b.mp4", Type: videoFileType, Local: true, Size: 100, Modified: at(-2 * time.Hour),
+			artifactSession: artifactSession{SessionId: "b", Quota: "bob", Browser: "firefox", Labels: map[string]string{"team": "search"}}},
+		{Name: "c.log", Type: logFileType, Local: false, Size: 200, Modified: at(-time.Hour),
+			artifactSession: artifactSession{SessionId: "c", Quota: "alice", Browser: "chrome", Labels: map[string]string{"team": "checkout", "ci": "true"}}},
+	}
+}
+
+func filteredNames(t *testing.T, query string) ([]string, int) {
+	q, err := url.ParseQuery(query)
+	assert.NoError(t, err)
+	files, total, err := filterFiles(testListedFiles(), q)
+	assert.NoError(t, err)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	return names, total
+}
+
+func TestFilterFiles(t *testing.T) {
+	for query, expected := range map[string][]string{
+		"":                                  {"a.mp4", "b.mp4", "c.log"},
+		"type=video":                        {"a.mp4", "b.mp4"},
+		"quota=alice&browser=chrome":        {"a.mp4", "c.log"},
+		"sessionId=b":                       {"b.mp4"},
+		"label=ci":                          {"c.log"},
+		"label=team=checkout":               {"a.mp4", "c.log"},
+		"label=team=checkout&label=ci=true": {"c.log"},
+		"local=false":                       {"c.log"},
+		"since=2026-10-18T09:30:00Z":        {"b.mp4", "c.log"},
+		"until=2026-10-18T10:00:00Z":        {"a.mp4", "b.mp4"},
+		"quota=missing":                     nil,
+	} {
+		names, total := filteredNames(t, query)
+		assert.Equal(t, names, expected, query)
+		assert.Equal(t, total, len(expected), query)
+	}
+}
+
+func TestSortAndPaginateFiles(t *testing.T) {
+	names, _ := filteredNames(t, "sort=size")
+	assert.Equal(t, names, []string{"b.mp4", "c.log", "a.mp4"})
+	names, _ = filteredNames(t, "sort=-modified")
+	assert.Equal(t, names, []string{"c.log", "b.mp4", "a.mp4"})
+
+	names, total := filteredNames(t, "sort=-size&offset=1&limit=1")
+	assert.Equal(t, names, []string{"c.log"})
+	assert.Equal(t, total, 3)
+	names, total = filteredNames(t, "offset=10")
+	assert.Empty(t, names)
+	assert.Equal(t, total, 3)
+}
+
+func TestFilterFilesInvalidParams(t *testing.T) {
+	for _, query := range []string{"sort=color", "limit=-1", "offset=x", "since=yesterday"} {
+		q, _ := url.ParseQuery(query)
+		_, _, err := filterFiles(testListedFiles(), q)
+		assert.Error(t, err, query)
+	}
+	rsp, err := http.Get(With(srv.URL).Path("/video/?json&details&sort=color"))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusBadRequest)
+}
+
+func TestArtifactIndex(t *testing.T) {
+	name := "indexed-video.mp4"
+	_ = os.WriteFile(filepath.Join(videoOutputDir, name), []byte("test-data"), 0644)
+	defer os.Remove(filepath.Join(videoOutputDir, name))
+
+	event.FileCreated(event.CreatedFile{
+		Event: event.Event{
+			SessionId: "indexed-session",
+			Session: &session.Session{
+				Quota: "alice",
+				Caps:  session.Caps{Name: "chrome", Version: "118.0", TestName: "LoginTest", Labels: map[string]string{"team": "checkout"}},
+			},
+		},
+		Name: filepath.Join(videoOutputDir, name),
+		Type: videoFileType,
+	})
+	waitFor(t, func() bool {
+		_, ok := artifacts.get(name, "")
+		return ok
+	})
+
+	files, err := listFiles(videoOutputDir, videoFileType)
+	assert.NoError(t, err)
+	artifacts.describe(files)
+	q, _ := url.ParseQuery("testName=LoginTest&label=team=checkout")
+	files, total, err := filterFiles(files, q)
+	assert.NoError(t, err)
+	assert.Equal(t, total, 1)
+	assert.Equal(t, files[0].Name, name)
+	assert.Equal(t, files[0].Type, videoFileType)
+	assert.Equal(t, files[0].artifactSession, artifactSession{
+		SessionId: "indexed-session", TestName: "LoginTest", Quota: "alice", Browser: "chrome", Version: "118.0",
+		Labels: map[string]string{"team": "checkout"},
+	})
+
+	artifacts.forget(name)
+	_, ok := artifacts.get(name, "")
+	assert.False(t, ok)
+}
+
+func TestArtifactIndexLimit(t *testing.T) {
+	ai := newArtifactIndex()
+	ai.limit = 2
+	created := func(sessionId string, name string) {
+		ai.OnFileCreated(event.CreatedFile{
+			Event: event.Event{SessionId: sessionId, Session: &session.Session{}},
+			Name:  name,
+			Type:  videoFileType,
+		})
+	}
+	created("s1", "s1.mp4")
+	created("s2", "s2.mp4")
+	created("s1", "s1.log")
+	ai.forget("s2.mp4")
+	created("s3", "s3.mp4")
+	assert.Len(t, ai.names("s1"), 2)
+	assert.Empty(t, ai.names("s2"))
+
+	created("s4", "s4.mp4")
+	assert.Empty(t, ai.names("s1"))
+	assert.Len(t, ai.names("s3"), 1)
+	assert.Len(t, ai.names("s4"), 1)
+	assert.Len(t, ai.files, 2)
+	assert.Equal(t, ai.order.Len(), 2)
+
+	// File recreated by another session belongs to it only
+	created("s4", "s3.mp4")
+	assert.Empty(t, ai.names("s3"))
+	assert.Len(t, ai.names("s4"), 2)
+}
+
+func TestArtifactIndexSavedMetadata(t *testing.T) {
+	ai := newArtifactIndex()
+	ai.limit = 1
+	saveMetadata := func(sessionId string) string {
+		name := filepath.Join(logOutputDir, sessionId+metadataFileExtension)
+		meta, _ := json.Marshal(session.Metadata{ID: sessionId, Capabilities: session.Caps{Name: "firefox"}})
+		assert.NoError(t, os.WriteFile(name, meta, 0644))
+		return name
+	}
+
+	first := saveMetadata("saved-session-1")
+	files := []*listedFile{{Name: "saved-session-1.mp4"}, {Name: "saved-session-1.log"}, {Name: "unknown.mp4"}}
+	ai.describe(files)
+	assert.Equal(t, files[0].artifactSession, artifactSession{SessionId: "saved-session-1", Browser: "firefox"})
+	assert.Equal(t, files[1].artifactSession, files[0].artifactSession)
+	assert.Empty(t, files[2].SessionId)
+
+	// Metadata is read from disk once
+	assert.NoError(t, os.Remove(first))
+	_, ok := ai.get("saved-session-1.mp4", "")
+	assert.True(t, ok)
+
+	second := saveMetadata("saved-session-2")
+	defer os.Remove(second)
+	_, ok = ai.get("saved-session-2.mp4", "")
+	assert.True(t, ok)
+	_, ok = ai.get("saved-session-1.mp4", "")
+	assert.False(t, ok)
+	assert.Len(t, ai.saved, 1)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/aerokube/selenoid/jsonerror"
+	"github.com/aerokube/selenoid/session"
+)
+
+// Session idle timer is restarted not more often than this on BiDi traffic
+const bidiTouchInterval = time.Second
+
+// bidiBackend - BiDi URL returned by driver made reachable from Selenoid, loopback addresses and container host name are replaced by driver host.
+// Origin is driver address inside container, its port is replaced by driver port published to Selenoid.
+func bidiBackend(webSocketUrl string, driver *url.URL, origin string) (*url.URL, error) {
+	u, err := url.Parse(webSocketUrl)
+	if err != nil {
+		return nil, fmt.Errorf("parse webSocketUrl: %v", err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("unsupported webSocketUrl scheme: %s", u.Scheme)
+	}
+	host := u.Hostname()
+	originHost, originPort, _ := net.SplitHostPort(origin)
+	if ip := net.ParseIP(host); host == "localhost" || (originHost != "" && host == originHost) || (ip != nil && (ip.IsLoopback() || ip.IsUnspecified())) {
+		port := u.Port()
+		if port == "" || port == originPort {
+			port = driver.Port()
+		}
+		u.Host = net.JoinHostPort(driver.Hostname(), port)
+	}
+	return u, nil
+}
+
+// resetTimeout - restarts idle timer of running session
+func resetTimeout(requestId uint64, r *http.Request, sid string, sess *session.Session) {
+	sess.Lock.Lock()
+	defer sess.Lock.Unlock()
+	if _, ok := sessions.Get(sid); !ok {
+		return
+	}
+	select {
+	case <-sess.TimeoutCh:
+	default:
+		close(sess.TimeoutCh)
+	}
+	sess.Touch()
+	sess.TimeoutCh = onTimeout(sess.Timeout, func() {
+		request{r}.session(sid).Delete(requestId)
+	})
+}
+
+// bidiConn - connection to driver BiDi endpoint restarting session idle timer when messages are sent or received
+type bidiConn struct {
+	io.ReadWriteCloser
+	touch func()
+}
+
+func (bc *bidiConn) Read(p []byte) (int, error) {
+	n, err := bc.ReadWriteCloser.Read(p)
+	if n > 0 {
+		bc.touch()
+	}
+	return n, err
+}
+
+func (bc *bidiConn) Write(p []byte) (int, error) {
+	n, err := bc.ReadWriteCloser.Write(p)
+	if n > 0 {
+		bc.touch()
+	}
+	return n, err
+}
+
+func bidi(w http.ResponseWriter, r *http.Request) {
+	requestId := serial()
+	sid, _ := splitRequestPath(r.URL.Path)
+	sess, ok := sessions.Get(sid)
+	if !ok || sess.BiDi == nil {
+		jsonerror.InvalidSessionID(fmt.Errorf("unknown session %s", sid)).Encode(w)
+		log.Printf("[%d] [SESSION_NOT_FOUND] [%s]", requestId, sid)
+		return
+	}
+	resetTimeout(requestId, r, sid, sess)
+	var lock sync.Mutex
+	touched := time.Now()
+	touch := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		if time.Since(touched) < bidiTouchInterval {
+			return
+		}
+		touched = time.Now()
+		resetTimeout(requestId, r, sid, sess)
+	}
+	(&httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			if sess.BiDi.Scheme == "wss" {
+				r.URL.Scheme = "https"
+			}
+			r.URL.Host, r.URL.Path, r.URL.RawQuery = sess.BiDi.Host, sess.BiDi.Path, sess.BiDi.RawQuery
+			r.Host = "localhost"
+			if sess.Origin != "" {
+				r.Host = sess.Origin
+			}
+			log.Printf("[%d] [BIDI] [%s] [%s]", requestId, sid, sess.BiDi.Path)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if rwc, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+				resp.Body = &bidiConn{ReadWriteCloser: rwc, touch: touch}
+			}
+			return nil
+		},
+		ErrorHandler: defaultErrorHandler(requestId),
+	}).ServeHTTP(w, r)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestBiDiBackend(t *testing.T) {
+	driver, _ := url.Parse("http://172.17.0.3:4444/wd/hub")
+	for webSocketUrl, expected := range map[string]string{
+		"ws://127.0.0.1:9222/session/123":       "ws://172.17.0.3:9222/session/123",
+		"ws://localhost:4444/session/123":       "ws://172.17.0.3:4444/session/123",
+		"ws://0.0.0.0:9222/session/123":         "ws://172.17.0.3:9222/session/123",
+		"ws://[::1]/session/123":                "ws://172.17.0.3:4444/session/123",
+		"ws://browser.example.com:9222/session": "ws://browser.example.com:9222/session",
+	} {
+		u, err := bidiBackend(webSocketUrl, driver, "")
+		assert.NoError(t, err)
+		assert.Equal(t, u.String(), expected)
+	}
+	_, err := bidiBackend("http://127.0.0.1:9222/session/123", driver, "")
+	assert.Error(t, err)
+}
+
+func TestBiDiBackendPublishedPort(t *testing.T) {
+	// Driver listening on port 4444 inside container is published to host port 32768
+	driver, _ := url.Parse("http://127.0.0.1:32768/")
+	for webSocketUrl, expected := range map[string]string{
+		"ws://127.0.0.1:4444/session/123":       "ws://127.0.0.1:32768/session/123",
+		"ws://a1b2c3d4e5f6:4444/session/123":    "ws://127.0.0.1:32768/session/123",
+		"ws://localhost:9222/session/123":       "ws://127.0.0.1:9222/session/123",
+		"ws://browser.example.com:4444/session": "ws://browser.example.com:4444/session",
+	} {
+		u, err := bidiBackend(webSocketUrl, driver, "a1b2c3d4e5f6:4444")
+		assert.NoError(t, err)
+		assert.Equal(t, u.String(), expected)
+	}
+}
+
+func TestProcessBodyWebSocketUrl(t *testing.T) {
+	input := []byte(`{"value":{"sessionId":"123","capabilities":{"browserVersion":"120.0","webSocketUrl":"ws://127.0.0.1:9222/session/123"}}}`)
+	output, sessionId, webSocketUrl, err := processBody(input, "ws", "selenoid.example.com:4444", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, sessionId, "123")
+	assert.Equal(t, webSocketUrl, "ws://127.0.0.1:9222/session/123")
+	var body struct {
+		Value struct {
+			Capabilities map[string]interface{} `json:"capabilities"`
+		} `json:"value"`
+	}
+	assert.NoError(t, json.Unmarshal(output, &body))
+	assert.Equal(t, body.Value.Capabilities["webSocketUrl"], "ws://selenoid.example.com:4444/bidi/123")
+	assert.Equal(t, body.Value.Capabilities["se:cdp"], "ws://selenoid.example.com:4444/devtools/123/")
+
+	output, _, _, err = processBody(input, "wss", "selenoid.example.com", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(output, &body))
+	assert.Equal(t, body.Value.Capabilities["webSocketUrl"], "wss://selenoid.example.com/bidi/123")
+	assert.Equal(t, body.Value.Capabilities["se:cdp"], "wss://selenoid.example.com/devtools/123/")
+
+	_, _, webSocketUrl, err = processBody([]byte(`{"value":{"sessionId":"123","capabilities":{"webSocketUrl":true}}}`), "ws", "selenoid.example.com:4444", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, webSocketUrl)
+}
+
+func TestBiDi(t *testing.T) {
+	var backend *httptest.Server
+	upgrader := websocket.Upgrader{}
+	selenium := Selenium(func(input map[string]interface{}) {
+		input["value"] = map[string]interface{}{
+			"sessionId": input["sessionId"],
+			"capabilities": map[string]interface{}{
+				"webSocketUrl": fmt.Sprintf("ws://127.0.0.1:%s/session/%s", backend.URL[strings.LastIndex(backend.URL, ":")+1:], input["sessionId"]),
+			},
+		}
+		delete(input, "sessionId")
+	})
+	var bidiHost string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/session/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "" {
+			selenium.ServeHTTP(w, r)
+			return
+		}
+		bidiHost = r.Host
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			_ = c.WriteMessage(mt, msg)
+		}
+	})
+	mux.Handle("/session", selenium)
+	manager = &HTTPTest{Handler: mux, Action: func(s *httptest.Server) {
+		backend = s
+	}}
+
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"capabilities":{"alwaysMatch":{"browserName":"firefox","webSocketUrl":true,"selenoid:options":{"sessionTimeout":"2s"}}}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var body struct {
+		Value struct {
+			SessionId    string                 `json:"sessionId"`
+			Capabilities map[string]interface{} `json:"capabilities"`
+		} `json:"value"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	sid := body.Value.SessionId
+	t.Cleanup(func() {
+		if _, ok := sessions.Get(sid); ok {
+			sessions.Remove(sid)
+			queue.Release()
+		}
+	})
+	webSocketUrl := body.Value.Capabilities["webSocketUrl"]
+	assert.Equal(t, webSocketUrl, fmt.Sprintf("ws://%s/bidi/%s", srv.Listener.Addr().String(), sid))
+
+	conn, _, err := websocket.DefaultDialer.Dial(webSocketUrl.(string), nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	assert.Equal(t, bidiHost, "localhost")
+	// Traffic keeps session alive longer than its idle timeout
+	for i := 0; i < 6; i++ {
+		msg := fmt.Sprintf(`{"id":%d,"method":"session.status","params":{}}`, i)
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(msg)))
+		_, reply, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, string(reply), msg)
+		time.Sleep(500 * time.Millisecond)
+	}
+	_, ok := sessions.Get(sid)
+	assert.True(t, ok)
+
+	req, _ := http.NewRequest(http.MethodDelete, With(srv.URL).Path("/wd/hub/session/"+sid), nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+}
+
+func TestBiDiUnknownSession(t *testing.T) {
+	rsp, err := http.Get(With(srv.URL).Path("/bidi/missing"))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusNotFound)
+}
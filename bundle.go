@@ -0,0 +1,293 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aerokube/selenoid/info"
+	"github.com/aerokube/selenoid/session"
+	"github.com/aerokube/selenoid/upload"
+)
+
+const (
+	downloadFileType = "download"
+	bundleExtension  = ".zip"
+	downloadsDir     = "downloads"
+)
+
+// sessionArtifact - file produced by session with URL to download it from
+type sessionArtifact struct {
+	listedFile
+	URL string `json:"url"`
+}
+
+func artifactDir(fileType string) string {
+	switch fileType {
+	case videoFileType, subtitlesFileType, vncRecordingFileType:
+		return videoOutputDir
+	case screenshotFileType, pageSourceFileType:
+		return screenshotOutputDir
+	}
+	return logOutputDir
+}
+
+// sessionFiles - local and uploaded video, log and metadata files produced by session
+func sessionFiles(sessionId string) []*listedFile {
+	candidates := artifacts.names(sessionId)
+	add := func(name string, fileType string) {
+		name = filepath.Base(name)
+		if _, ok := candidates[name]; !ok && name != "." && name != slash {
+			candidates[name] = fileType
+		}
+	}
+	// Files created before restart are found by names from session metadata or by default names
+	if meta, ok := readMetadata(sessionId); ok {
+		if videoName := meta.Capabilities.VideoName; videoName != "" {
+			add(videoName, videoFileType)
+			add(strings.TrimSuffix(videoName, filepath.Ext(videoName))+subtitlesFileExtension, subtitlesFileType)
+		}
+		if meta.Capabilities.LogName != "" {
+			add(meta.Capabilities.LogName, logFileType)
+		}
+	}
+	add(sessionId+videoFileExtension, videoFileType)
+	add(sessionId+subtitlesFileExtension, subtitlesFileType)
+	add(sessionId+logFileExtension, logFileType)
+	add(sessionId+metadataFileExtension, metadataFileType)
+	add(sessionId+screenshotFileExtension, screenshotFileType)
+	add(sessionId+pageSourceFileExtension, pageSourceFileType)
+	add(sessionId+commandLogFileExtension, commandLogFileType)
+	add(sessionId+harFileExtension, harFileType)
+	add(sessionId+consoleLogFileExtension, consoleLogFileType)
+	add(sessionId+vncRecordingFileExtension, vncRecordingFileType)
+	uploaded := make(map[string][]upload.Artifact)
+	for _, a := range upload.Artifacts() {
+		if a.SessionId == sessionId {
+			add(a.Name, a.Type)
+		}
+		uploaded[a.Name] = append(uploaded[a.Name], a)
+	}
+	var ret []*listedFile
+	for name, fileType := range candidates {
+		lf := &listedFile{Name: name, Type: fileType}
+		if dir := artifactDir(fileType); dir != "" {
+			if fi, err := os.Stat(filepath.Join(dir, name)); err == nil && fi.Mode().IsRegular() {
+				modified := fi.ModTime()
+				lf.Local, lf.Size, lf.Modified = true, fi.Size(), &modified
+			}
+		}
+		for _, a := range uploaded[name] {
+			if lf.Modified == nil {
+				modified := a.Uploaded
+				lf.Modified = &modified
+			}
+			lf.Locations = append(lf.Locations, a.Location)
+		}
+		if !lf.Local && len(lf.Locations) == 0 {
+			continue
+		}
+		if as, ok := artifacts.get(name, sessionId); ok {
+			lf.artifactSession = as
+		}
+		ret = append(ret, lf)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// sessionDownloads - names of files downloaded by browser of running session
+func sessionDownloads(ctx context.Context, sess *session.Session) ([]string, error) {
+	if sess.HostPort.Fileserver == "" {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	u := &url.URL{Scheme: "http", Host: sess.HostPort.Fileserver, Path: slash, RawQuery: jsonParam}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("list downloads: %v", err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list downloads: %s", rsp.Status)
+	}
+	var names []string
+	if err := json.NewDecoder(rsp.Body).Decode(&names); err != nil {
+		return nil, fmt.Errorf("list downloads: %v", err)
+	}
+	var ret []string
+	for _, name := range names {
+		if name != "" && name != "." && name != ".." && !strings.Contains(name, slash) {
+			ret = append(ret, name)
+		}
+	}
+	return ret, nil
+}
+
+func sessionArtifacts(w http.ResponseWriter, r *http.Request) {
+	requestId := serial()
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, remote := info.RequestInfo(r)
+	sid, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, paths.Artifacts), slash)
+	bundle := name == "" && strings.HasSuffix(sid, bundleExtension)
+	sid = strings.TrimSuffix(sid, bundleExtension)
+	files := sessionFiles(sid)
+	sess, running := sessions.Get(sid)
+	var downloads []string
+	if running {
+		var err error
+		downloads, err = sessionDownloads(r.Context(), sess)
+		if err != nil {
+			log.Printf("[%d] [SESSION_ARTIFACTS_ERROR] [%s] [%v]", requestId, sid, err)
+		}
+	}
+	if sid == "" || (len(files) == 0 && !running) {
+		http.Error(w, fmt.Sprintf("Unknown session %s", sid), http.StatusNotFound)
+		return
+	}
+	switch {
+	case bundle:
+		log.Printf("[%d] [SESSION_BUNDLE] [%s] [%s] [%s]", requestId, user, remote, sid)
+		writeBundle(requestId, w, r, sid, files, sess, downloads)
+	case name != "":
+		for _, f := range files {
+			if f.Name == name {
+				log.Printf("[%d] [SESSION_ARTIFACT] [%s] [%s] [%s] [%s]", requestId, user, remote, sid, name)
+				serveArtifact(requestId, w, r, f)
+				return
+			}
+		}
+		http.Error(w, fmt.Sprintf("Unknown file %s", name), http.StatusNotFound)
+	default:
+		log.Printf("[%d] [SESSION_ARTIFACTS] [%s] [%s] [%s]", requestId, user, remote, sid)
+		ret := []sessionArtifact{}
+		for _, f := range files {
+			ret = append(ret, sessionArtifact{*f, paths.Artifacts + sid + slash + url.PathEscape(f.Name)})
+		}
+		for _, d := range downloads {
+			f := listedFile{Name: d, Type: downloadFileType, artifactSession: newArtifactSession(sid, sess.Quota, sess.Caps)}
+			ret = append(ret, sessionArtifact{f, paths.Download + sid + slash + url.PathEscape(d)})
+		}
+		w.Header().Add("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ret)
+	}
+}
+
+func serveArtifact(requestId uint64, w http.ResponseWriter, r *http.Request, f *listedFile) {
+	if f.Local {
+		http.ServeFile(w, r, filepath.Join(artifactDir(f.Type), f.Name))
+		return
+	}
+	link, ok, err := upload.Link(f.Name, f.Type)
+	if err != nil {
+		log.Printf("[%d] [SESSION_ARTIFACT_ERROR] [%s] [%v]", requestId, f.Name, err)
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("No link to uploaded file %s", f.Name), http.StatusNotFound)
+		return
+	}
+	serveLink(w, r, link)
+}
+
+// openArtifact - reads local file or its uploaded copy
+func openArtifact(ctx context.Context, f *listedFile) (io.ReadCloser, error) {
+	if f.Local {
+		return os.Open(filepath.Join(artifactDir(f.Type), f.Name))
+	}
+	link, ok, err := upload.Link(f.Name, f.Type)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no link to uploaded file")
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "file" {
+		return os.Open(filepath.FromSlash(u.Path))
+	}
+	return openURL(ctx, link)
+}
+
+func openURL(ctx context.Context, link string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		_ = rsp.Body.Close()
+		return nil, fmt.Errorf("download: %s", rsp.Status)
+	}
+	return rsp.Body, nil
+}
+
+// writeBundle - streams zip with session files and files downloaded by browser
+func writeBundle(requestId uint64, w http.ResponseWriter, r *http.Request, sid string, files []*listedFile, sess *session.Session, downloads []string) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, sid+bundleExtension))
+	zw := zip.NewWriter(w)
+	add := func(name string, method uint16, modified time.Time, open func() (io.ReadCloser, error)) {
+		rc, err := open()
+		if err != nil {
+			log.Printf("[%d] [SESSION_BUNDLE_ERROR] [%s] [%s] [%v]", requestId, sid, name, err)
+			return
+		}
+		defer rc.Close()
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modified})
+		if err == nil {
+			_, err = io.Copy(fw, rc)
+		}
+		if err != nil {
+			log.Printf("[%d] [SESSION_BUNDLE_ERROR] [%s] [%s] [%v]", requestId, sid, name, err)
+		}
+	}
+	for _, f := range files {
+		method, modified := zip.Deflate, time.Now()
+		if f.Type == videoFileType || f.Type == vncRecordingFileType {
+			// Video and VNC recording are already compressed
+			method = zip.Store
+		}
+		if f.Modified != nil {
+			modified = *f.Modified
+		}
+		add(f.Name, method, modified, func() (io.ReadCloser, error) {
+			return openArtifact(r.Context(), f)
+		})
+	}
+	for _, d := range downloads {
+		u := &url.URL{Scheme: "http", Host: sess.HostPort.Fileserver, Path: slash + d}
+		add(path.Join(downloadsDir, d), zip.Deflate, time.Now(), func() (io.ReadCloser, error) {
+			return openURL(r.Context(), u.String())
+		})
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("[%d] [SESSION_BUNDLE_ERROR] [%s] [%v]", requestId, sid, err)
+	}
+}
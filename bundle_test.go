@@ -0,0 +1,111 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/aerokube/selenoid/session"
+	assert "github.com/stretchr/testify/require"
+)
+
+func readBundle(t *testing.T, sid string) map[string]string {
+	rsp, err := http.Get(With(srv.URL).Path("/artifacts/" + sid + ".zip"))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	assert.Equal(t, rsp.Header.Get("Content-Type"), "application/zip")
+	data, err := io.ReadAll(rsp.Body)
+	assert.NoError(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	ret := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		_ = rc.Close()
+		ret[f.Name] = string(content)
+	}
+	return ret
+}
+
+func TestSessionArtifacts(t *testing.T) {
+	sid := "bundled-session"
+	meta, _ := json.Marshal(session.Metadata{ID: sid, Capabilities: session.Caps{Name: "firefox", VideoName: "custom.mp4"}})
+	files := map[string][]byte{
+		filepath.Join(videoOutputDir, "custom.mp4"):       []byte("video-data"),
+		filepath.Join(logOutputDir, sid+logFileExtension): []byte("log-data"),
+		filepath.Join(logOutputDir, sid+".json"):          meta,
+	}
+	for name, data := range files {
+		assert.NoError(t, os.WriteFile(name, data, 0644))
+		defer os.Remove(name)
+	}
+
+	rsp, err := http.Get(With(srv.URL).Path("/artifacts/" + sid))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	var listed []sessionArtifact
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&listed))
+	var names, urls []string
+	for _, a := range listed {
+		names = append(names, a.Name+":"+a.Type)
+		urls = append(urls, a.URL)
+		assert.Equal(t, a.SessionId, sid)
+		assert.True(t, a.Local)
+	}
+	assert.Equal(t, names, []string{"bundled-session.json:metadata", "bundled-session.log:log", "custom.mp4:video"})
+	sort.Strings(urls)
+	assert.Equal(t, urls[2], "/artifacts/bundled-session/custom.mp4")
+
+	rsp, err = http.Get(With(srv.URL).Path("/artifacts/" + sid + "/" + sid + ".json"))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	data, _ := io.ReadAll(rsp.Body)
+	assert.Equal(t, data, meta)
+
+	assert.Equal(t, readBundle(t, sid), map[string]string{
+		"custom.mp4":           "video-data",
+		"bundled-session.log":  "log-data",
+		"bundled-session.json": string(meta),
+	})
+
+	for _, p := range []string{"/artifacts/missing-session", "/artifacts/missing-session.zip", "/artifacts/" + sid + "/other.log", "/artifacts/"} {
+		rsp, err = http.Get(With(srv.URL).Path(p))
+		assert.NoError(t, err)
+		assert.Equal(t, rsp.StatusCode, http.StatusNotFound, p)
+	}
+}
+
+func TestSessionArtifactsDownloads(t *testing.T) {
+	manager = &HTTPTest{Handler: Selenium()}
+
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte("{}")))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var sess map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sess))
+	sid := sess["sessionId"]
+	defer func() {
+		sessions.Remove(sid)
+		queue.Release()
+	}()
+
+	rsp, err := http.Get(With(srv.URL).Path("/artifacts/" + sid))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	var listed []sessionArtifact
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&listed))
+	assert.Len(t, listed, 1)
+	assert.Equal(t, listed[0].Type, downloadFileType)
+	assert.Equal(t, listed[0].URL, "/download/"+sid+"/testfile")
+
+	assert.Equal(t, readBundle(t, sid), map[string]string{"downloads/testfile": "test-data"})
+}
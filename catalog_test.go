@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aerokube/selenoid/upload"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCatalog(t *testing.T) {
+	dir, err := os.MkdirTemp("", "selenoid-catalog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := upload.NewCatalog(dir, upload.CatalogSessions)
+	assert.NoError(t, err)
+	c.Add(upload.Artifact{Name: "video.mp4", Type: "video", Location: "s3://bucket/video.mp4", Uploaded: time.Now()})
+	c.Add(upload.Artifact{Name: "video.mp4", Type: "video", Location: "file:///archive/video.mp4", Uploaded: time.Now()})
+	c.Add(upload.Artifact{Name: "session.log", Type: "log", Location: "s3://bucket/session.log", Uploaded: time.Now()})
+	assert.Len(t, c.Get("video.mp4"), 2)
+	assert.Len(t, c.List("log"), 1)
+	assert.True(t, c.Forget("session.log"))
+	assert.False(t, c.Forget("session.log"))
+
+	c, err = upload.NewCatalog(dir, upload.CatalogSessions)
+	assert.NoError(t, err)
+	artifacts := c.List()
+	assert.Len(t, artifacts, 2)
+	assert.Equal(t, artifacts[0].Location, "file:///archive/video.mp4")
+	assert.Equal(t, artifacts[1].Location, "s3://bucket/video.mp4")
+	assert.Empty(t, c.Get("session.log"))
+}
+
+func TestCatalogLimit(t *testing.T) {
+	dir, err := os.MkdirTemp("", "selenoid-catalog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := upload.NewCatalog(dir, 2)
+	assert.NoError(t, err)
+	add := func(sessionId string, name string) {
+		c.Add(upload.Artifact{Name: name, SessionId: sessionId, Location: "s3://bucket/" + name, Uploaded: time.Now()})
+	}
+	add("s1", "s1.mp4")
+	add("s2", "s2.mp4")
+	add("s1", "s1.log")
+	add("s3", "s3.mp4")
+	assert.Empty(t, c.Get("s1.mp4"))
+	assert.Empty(t, c.Get("s1.log"))
+	assert.Len(t, c.List(), 2)
+
+	// File recreated by another session belongs to it only
+	add("s3", "s2.mp4")
+	assert.Len(t, c.Get("s2.mp4"), 1)
+	add("s4", "s4.mp4")
+	assert.Len(t, c.List(), 3)
+
+	for i := 0; i < 1000; i++ {
+		add(fmt.Sprintf("session-%d", i), fmt.Sprintf("session-%d.mp4", i))
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "artifacts.jsonl"))
+	assert.NoError(t, err)
+	assert.Less(t, bytes.Count(data, []byte("\n")), 1000)
+
+	c, err = upload.NewCatalog(dir, 2)
+	assert.NoError(t, err)
+	artifacts := c.List()
+	assert.Len(t, artifacts, 2)
+	assert.Equal(t, artifacts[0].Name, "session-998.mp4")
+	assert.Equal(t, artifacts[1].Name, "session-999.mp4")
+}
@@ -0,0 +1,114 @@
+// Command fbs2gif converts VNC recording saved by Selenoid to animated GIF
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aerokube/selenoid/rfb"
+)
+
+// Delay of the last frame
+const lastFrameDelay = time.Second
+
+func main() {
+	var (
+		output    string
+		frameRate int
+	)
+	flag.StringVar(&output, "output", "", "Output GIF file, input name with .gif extension by default")
+	flag.IntVar(&frameRate, "frame-rate", 5, "Maximum number of frames per second")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <session-id>.fbs.gz\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || frameRate <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input := flag.Arg(0)
+	if output == "" {
+		output = strings.TrimSuffix(strings.TrimSuffix(input, ".gz"), ".fbs") + ".gif"
+	}
+	if err := convert(input, output, time.Second/time.Duration(frameRate)); err != nil {
+		log.Fatalf("Failed to convert %s: %v", input, err)
+	}
+}
+
+func convert(input string, output string, interval time.Duration) error {
+	f, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(input, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		r = gz
+	}
+	p, err := rfb.NewPlayer(r)
+	if err != nil {
+		return err
+	}
+	anim := &gif.GIF{}
+	var shown, last time.Duration
+	addFrame := func(timestamp time.Duration) {
+		if len(anim.Image) > 0 {
+			anim.Delay[len(anim.Delay)-1] = int((timestamp - shown) / (10 * time.Millisecond))
+		}
+		// Only changed part of the screen is stored, it is drawn over previous frames
+		frame := image.NewPaletted(p.Damage, palette.WebSafe)
+		draw.Draw(frame, frame.Rect, p.RGBA, frame.Rect.Min, draw.Src)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, int(lastFrameDelay/(10*time.Millisecond)))
+		if b := p.Bounds(); b.Dx() > anim.Config.Width || b.Dy() > anim.Config.Height {
+			anim.Config.Width, anim.Config.Height = b.Dx(), b.Dy()
+		}
+		p.Damage = image.Rectangle{}
+		shown = timestamp
+	}
+	for {
+		timestamp, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// Recording of interrupted session can be truncated
+			log.Printf("Stopped reading %s: %v", input, err)
+			break
+		}
+		last = timestamp
+		if !p.Damage.Empty() && (len(anim.Image) == 0 || timestamp-shown >= interval) {
+			addFrame(timestamp)
+		}
+	}
+	if !p.Damage.Empty() {
+		addFrame(last)
+	}
+	anim.Config.ColorModel = color.Palette(palette.WebSafe)
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := gif.EncodeAll(out, anim); err != nil {
+		return err
+	}
+	return out.Close()
+}
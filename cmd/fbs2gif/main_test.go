@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aerokube/selenoid/rfb"
+	assert "github.com/stretchr/testify/require"
+)
+
+var (
+	red   = color.RGBA{R: 0xFF, A: 0xFF}
+	green = color.RGBA{G: 0xFF, A: 0xFF}
+	blue  = color.RGBA{B: 0xFF, A: 0xFF}
+)
+
+// update - framebuffer update with one raw rectangle filled with colour in recording pixel format
+func update(x, y, w, h uint16, c color.RGBA) []byte {
+	msg := []byte{rfb.FramebufferUpdate, 0, 0, 1}
+	for _, v := range []uint16{x, y, w, h} {
+		msg = binary.BigEndian.AppendUint16(msg, v)
+	}
+	msg = binary.BigEndian.AppendUint32(msg, rfb.EncodingRaw)
+	return append(msg, bytes.Repeat([]byte{c.B, c.G, c.R, 0}, int(w)*int(h))...)
+}
+
+// record - saves gzipped recording of 2x1 screen with given messages
+func record(t *testing.T, name string, messages ...[]byte) {
+	f, err := os.Create(name)
+	assert.NoError(t, err)
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	w, err := rfb.NewWriter(gz, rfb.ServerInit{Width: 2, Height: 1, PixelFormat: rfb.RecordingPixelFormat, Name: "test"})
+	assert.NoError(t, err)
+	for _, msg := range messages {
+		assert.NoError(t, w.WriteMessage(msg))
+	}
+	assert.NoError(t, gz.Close())
+}
+
+func decode(t *testing.T, name string) *gif.GIF {
+	f, err := os.Open(name)
+	assert.NoError(t, err)
+	defer f.Close()
+	anim, err := gif.DecodeAll(f)
+	assert.NoError(t, err)
+	return anim
+}
+
+func TestConvert(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "session.fbs.gz")
+	record(t, input, update(0, 0, 2, 1, red), update(1, 0, 1, 1, green), update(0, 0, 1, 1, blue))
+
+	output := filepath.Join(dir, "session.gif")
+	assert.NoError(t, convert(input, output, 0))
+	anim := decode(t, output)
+	assert.Equal(t, anim.Config.Width, 2)
+	assert.Equal(t, anim.Config.Height, 1)
+	assert.Len(t, anim.Image, 3)
+	assert.Equal(t, anim.Delay[2], int(lastFrameDelay/(10*time.Millisecond)))
+	// Frames contain only changed parts of the screen
+	assert.Equal(t, anim.Image[0].Rect, image.Rect(0, 0, 2, 1))
+	assert.Equal(t, color.RGBAModel.Convert(anim.Image[0].At(0, 0)), red)
+	assert.Equal(t, anim.Image[1].Rect, image.Rect(1, 0, 2, 1))
+	assert.Equal(t, color.RGBAModel.Convert(anim.Image[1].At(1, 0)), green)
+	assert.Equal(t, anim.Image[2].Rect, image.Rect(0, 0, 1, 1))
+	assert.Equal(t, color.RGBAModel.Convert(anim.Image[2].At(0, 0)), blue)
+
+	// Updates received faster than frame rate are merged into the last frame
+	assert.NoError(t, convert(input, output, time.Hour))
+	anim = decode(t, output)
+	assert.Len(t, anim.Image, 2)
+	assert.Equal(t, anim.Image[1].Rect, image.Rect(0, 0, 2, 1))
+	assert.Equal(t, color.RGBAModel.Convert(anim.Image[1].At(0, 0)), blue)
+	assert.Equal(t, color.RGBAModel.Convert(anim.Image[1].At(1, 0)), green)
+
+	// Recording of interrupted session is converted up to the last complete message
+	data, err := os.ReadFile(input)
+	assert.NoError(t, err)
+	truncated := filepath.Join(dir, "truncated.fbs.gz")
+	assert.NoError(t, os.WriteFile(truncated, data[:len(data)-12], 0644))
+	assert.NoError(t, convert(truncated, output, 0))
+	assert.NotEmpty(t, decode(t, output).Image)
+}
+
+func TestConvertErrors(t *testing.T) {
+	dir := t.TempDir()
+	assert.Error(t, convert(filepath.Join(dir, "missing.fbs"), filepath.Join(dir, "missing.gif"), 0))
+
+	input := filepath.Join(dir, "session.fbs")
+	assert.NoError(t, os.WriteFile(input, []byte("RFB 003.008\n"), 0644))
+	assert.EqualError(t, convert(input, filepath.Join(dir, "session.gif"), 0), "not an FBS 1.0 file")
+}
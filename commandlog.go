@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aerokube/selenoid/event"
+)
+
+const (
+	// Bodies are only redacted and saved when they fit into this limit
+	commandLogCaptureLimit = 1 << 20
+	redacted               = "[REDACTED]"
+)
+
+var (
+	commandLogsLock sync.Mutex
+	commandLogs     = make(map[string]*commandLog)
+
+	// Lowercase JSON keys containing these words have their values redacted
+	secretKeys = []string{"password", "passwd", "secret", "token", "authorization", "cookie", "credential", "apikey", "api_key"}
+
+	// Requests of these commands contain text typed by test (Element Send Keys, Send Alert Text and Perform Actions)
+	typingCommand = regexp.MustCompile(`/(element/[^/]+/value|alert/text|actions)$`)
+	// Keys with typed text in requests of typing commands
+	typedTextKeys = []string{"text", "value"}
+
+	// First path segments of W3C WebDriver commands relative to session, empty one is Delete Session
+	webDriverCommands = map[string]bool{
+		"": true, "timeouts": true, "url": true, "back": true, "forward": true, "refresh": true, "title": true,
+		"window": true, "frame": true, "element": true, "elements": true, "shadow": true, "source": true, "execute": true,
+		"cookie": true, "actions": true, "alert": true, "screenshot": true, "print": true,
+	}
+)
+
+// isWebDriverCommand - whether path relative to session is a W3C WebDriver command, e.g. not Selenoid or driver vendor endpoint
+func isWebDriverCommand(relativePath string) bool {
+	command, _, _ := strings.Cut(strings.TrimPrefix(relativePath, slash), slash)
+	return webDriverCommands[command]
+}
+
+// loggedCommand - WebDriver command received by session
+type loggedCommand struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMs int64     `json:"durationMs"`
+	Request    string    `json:"request,omitempty"`
+	Response   string    `json:"response,omitempty"`
+}
+
+// commandLog - appends commands of one session to temporary file in log output directory
+type commandLog struct {
+	lock sync.Mutex
+	name string
+	file *os.File
+	enc  *json.Encoder
+}
+
+func startCommandLog(sid string) error {
+	name := filepath.Join(logOutputDir, getTemporaryFileName(logOutputDir, commandLogFileExtension))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("create command log: %v", err)
+	}
+	commandLogsLock.Lock()
+	defer commandLogsLock.Unlock()
+	commandLogs[sid] = &commandLog{name: name, file: f, enc: json.NewEncoder(f)}
+	return nil
+}
+
+func getCommandLog(sid string) (*commandLog, bool) {
+	commandLogsLock.Lock()
+	defer commandLogsLock.Unlock()
+	cl, ok := commandLogs[sid]
+	return cl, ok
+}
+
+// commandLogFiles - temporary files of running sessions
+func commandLogFiles() []string {
+	commandLogsLock.Lock()
+	defer commandLogsLock.Unlock()
+	var ret []string
+	for _, cl := range commandLogs {
+		ret = append(ret, cl.name)
+	}
+	return ret
+}
+
+func (cl *commandLog) add(c loggedCommand) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	if cl.file == nil {
+		return
+	}
+	_ = cl.enc.Encode(c)
+}
+
+func (cl *commandLog) close() error {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	err := cl.file.Close()
+	cl.file = nil
+	return err
+}
+
+// saveCommandLog - renames command log of finished session and notifies listeners
+func saveCommandLog(e event.Event, sid string) {
+	commandLogsLock.Lock()
+	cl, ok := commandLogs[sid]
+	delete(commandLogs, sid)
+	commandLogsLock.Unlock()
+	if !ok {
+		return
+	}
+	err := cl.close()
+	newName := filepath.Join(logOutputDir, e.SessionId+commandLogFileExtension)
+	if err == nil {
+		err = os.Rename(cl.name, newName)
+	}
+	if err != nil {
+		log.Printf("[%d] [COMMAND_LOG_ERROR] [%s]", e.RequestId, fmt.Sprintf("Failed to save %s to %s: %v", cl.name, newName, err))
+		return
+	}
+	event.FileCreated(event.CreatedFile{
+		Event: e,
+		Name:  newName,
+		Type:  commandLogFileType,
+	})
+}
+
+// capturedBody - keeps body prefix up to capture limit
+type capturedBody struct {
+	bytes.Buffer
+	size int
+	// redactKeys - keys with values redacted in addition to secret-looking ones
+	redactKeys []string
+}
+
+func (cb *capturedBody) Write(p []byte) (int, error) {
+	cb.size += len(p)
+	if cb.Len() < commandLogCaptureLimit {
+		cb.Buffer.Write(p[:min(len(p), commandLogCaptureLimit-cb.Len())])
+	}
+	return len(p), nil
+}
+
+func (cb *capturedBody) String() string {
+	if commandLogBodySize <= 0 || cb.size == 0 {
+		return ""
+	}
+	if cb.size > commandLogCaptureLimit {
+		return fmt.Sprintf("[%d bytes]", cb.size)
+	}
+	data := cb.Bytes()
+	if !utf8.Valid(data) {
+		return fmt.Sprintf("[%d bytes of binary data]", cb.size)
+	}
+	var v interface{}
+	if json.Unmarshal(data, &v) == nil {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		_ = enc.Encode(redact(v, cb.redactKeys))
+		data = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	}
+	if len(data) > commandLogBodySize {
+		return string(data[:commandLogBodySize]) + "..."
+	}
+	return string(data)
+}
+
+// redact - replaces values of keys looking like secrets and of given keys
+func redact(v interface{}, keys []string) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, nested := range value {
+			if isSecretKey(k, keys) {
+				value[k] = redacted
+				continue
+			}
+			value[k] = redact(nested, keys)
+		}
+	case []interface{}:
+		for i, nested := range value {
+			value[i] = redact(nested, keys)
+		}
+	}
+	return v
+}
+
+func isSecretKey(key string, keys []string) bool {
+	for _, k := range keys {
+		if key == k {
+			return true
+		}
+	}
+	key = strings.ToLower(key)
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandRecorder - captures response status and body
+type commandRecorder struct {
+	http.ResponseWriter
+	status int
+	body   capturedBody
+}
+
+func (cr *commandRecorder) WriteHeader(status int) {
+	cr.status = status
+	cr.ResponseWriter.WriteHeader(status)
+}
+
+func (cr *commandRecorder) Write(p []byte) (int, error) {
+	if cr.status == 0 {
+		cr.status = http.StatusOK
+	}
+	_, _ = cr.body.Write(p)
+	return cr.ResponseWriter.Write(p)
+}
+
+func (cr *commandRecorder) Flush() {
+	if f, ok := cr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cr *commandRecorder) Unwrap() http.ResponseWriter {
+	return cr.ResponseWriter
+}
+
+// recordCommand - serves request saving it to session command log and subtitles when they are enabled
+func recordCommand(sid string, w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	relativePath := strings.TrimPrefix(r.URL.Path, seleniumPaths.ProxySession+sid)
+	cl, logged := getCommandLog(sid)
+	logged = logged && isWebDriverCommand(relativePath)
+	st, subtitled := getSubtitles(sid)
+	if !logged && !subtitled {
+		next(w, r)
+		return
+	}
+	var request capturedBody
+	if typingCommand.MatchString(r.URL.Path) {
+		request.redactKeys = typedTextKeys
+	}
+	if r.Body != nil {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, &request), r.Body}
+	}
+	recorder := &commandRecorder{ResponseWriter: w}
+	start := time.Now()
+	next(recorder, r)
+	duration := time.Since(start)
+	if subtitled {
+		st.add(start, duration, commandName(r.Method, relativePath, request.Bytes()))
+	}
+	if !logged {
+		return
+	}
+	response := recorder.body.String()
+	// Returned cookies have no secret-looking keys
+	if strings.Contains(r.URL.Path, "/cookie") && response != "" {
+		response = redacted
+	}
+	status := recorder.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	cl.add(loggedCommand{
+		Time:       start,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Status:     status,
+		DurationMs: duration.Milliseconds(),
+		Request:    request.String(),
+		Response:   response,
+	})
+}
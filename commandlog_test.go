@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func withCommandLogBodySize(size int) func() {
+	old := commandLogBodySize
+	commandLogBodySize = size
+	return func() {
+		commandLogBodySize = old
+	}
+}
+
+func TestCommandLog(t *testing.T) {
+	defer withCommandLogBodySize(1024)()
+	manager = &HTTPTest{Handler: Selenium()}
+
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"desiredCapabilities":{"enableCommandLog":true}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var sess map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sess))
+	sid := sess["sessionId"]
+
+	resp, err = http.Post(With(srv.URL).Path("/wd/hub/session/"+sid+"/element/1/value"), "", strings.NewReader(`{"text":"hello","password":"secret-value"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	resp, err = http.Get(With(srv.URL).Path("/wd/hub/session/" + sid + "/source"))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	// Vendor endpoints are not WebDriver commands
+	_, err = http.Get(With(srv.URL).Path("/wd/hub/session/" + sid + "/aerokube/download/file.txt"))
+	assert.NoError(t, err)
+	_, err = http.Post(With(srv.URL).Path("/wd/hub/session/"+sid+"/goog/cdp/execute"), "", strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodDelete, With(srv.URL).Path("/wd/hub/session/"+sid), nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+
+	name := filepath.Join(logOutputDir, preprocessSessionId(sid)+commandLogFileExtension)
+	waitFor(t, func() bool {
+		_, err := os.Stat(name)
+		return err == nil
+	})
+	defer os.Remove(name)
+	f, err := os.Open(name)
+	assert.NoError(t, err)
+	defer f.Close()
+	var commands []loggedCommand
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var c loggedCommand
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &c))
+		commands = append(commands, c)
+	}
+	assert.Len(t, commands, 3)
+	assert.Equal(t, commands[0].Method, http.MethodPost)
+	assert.Equal(t, commands[0].Path, "/session/"+sid+"/element/1/value")
+	assert.Equal(t, commands[0].Status, http.StatusOK)
+	assert.Equal(t, commands[0].Request, `{"password":"[REDACTED]","text":"[REDACTED]"}`)
+	assert.Equal(t, commands[1].Response, `{"value":"<html></html>"}`)
+	assert.Equal(t, commands[2].Method, http.MethodDelete)
+	assert.Equal(t, fileTypeOf(name), commandLogFileType)
+}
+
+func TestCommandLogBodies(t *testing.T) {
+	defer withCommandLogBodySize(10)()
+	body := func(data string) string {
+		var cb capturedBody
+		_, _ = cb.Write([]byte(data))
+		return cb.String()
+	}
+	assert.Equal(t, body(""), "")
+	assert.Equal(t, body("0123456789abc"), "0123456789...")
+	assert.Equal(t, body(`{"token":"x"}`), `{"token":"...`)
+	assert.Equal(t, body("\xff\xfe"), "[2 bytes of binary data]")
+	assert.Equal(t, body(strings.Repeat("a", commandLogCaptureLimit+1)), "[1048577 bytes]")
+
+	defer withCommandLogBodySize(1024)()
+	assert.Equal(t, body(`{"cookie":{"name":"a","value":"b"},"nested":[{"apiKey":"k","accessToken":"t"}]}`),
+		`{"cookie":"[REDACTED]","nested":[{"accessToken":"[REDACTED]","apiKey":"[REDACTED]"}]}`)
+}
+
+func TestCommandLogTypedText(t *testing.T) {
+	defer withCommandLogBodySize(1024)()
+	body := func(path string, data string) string {
+		var cb capturedBody
+		if typingCommand.MatchString(path) {
+			cb.redactKeys = typedTextKeys
+		}
+		_, _ = cb.Write([]byte(data))
+		return cb.String()
+	}
+	assert.Equal(t, body("/session/1/element/2/value", `{"text":"secret","value":["s","e"]}`), `{"text":"[REDACTED]","value":"[REDACTED]"}`)
+	assert.Equal(t, body("/session/1/alert/text", `{"text":"secret"}`), `{"text":"[REDACTED]"}`)
+	assert.Equal(t, body("/session/1/actions", `{"actions":[{"type":"key","id":"k","actions":[{"type":"keyDown","value":"s"}]}]}`),
+		`{"actions":[{"actions":[{"type":"keyDown","value":"[REDACTED]"}],"id":"k","type":"key"}]}`)
+	assert.Equal(t, body("/session/1/element", `{"using":"css selector","value":"#login"}`), `{"using":"css selector","value":"#login"}`)
+}
+
+func TestNoCommandLogByDefault(t *testing.T) {
+	manager = &HTTPTest{Handler: Selenium()}
+
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte("{}")))
+	assert.NoError(t, err)
+	var sess map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sess))
+	sid := sess["sessionId"]
+	_, ok := getCommandLog(sid)
+	assert.False(t, ok)
+
+	sessions.Remove(sid)
+	queue.Release()
+}
+
+func TestIsWebDriverCommand(t *testing.T) {
+	for _, path := range []string{"", "/", "/url", "/element/1/value", "/window/handles", "/execute/sync", "/shadow/1/element"} {
+		assert.True(t, isWebDriverCommand(path), path)
+	}
+	for _, path := range []string{"/aerokube/download/file.txt", "/aerokube/clipboard", "/goog/cdp/execute", "/moz/context", "/se/log"} {
+		assert.False(t, isWebDriverCommand(path), path)
+	}
+}
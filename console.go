@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+	"github.com/mafredri/cdp"
+	"github.com/mafredri/cdp/protocol/runtime"
+	"golang.org/x/net/websocket"
+)
+
+// Entries not yet sent to slow viewers are dropped
+const consoleViewerBuffer = 100
+
+var (
+	consoleLogsLock sync.Mutex
+	consoleLogs     = make(map[string]*consoleLog)
+)
+
+// consoleEntry - browser console message, uncaught exception or browser log entry
+type consoleEntry struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Level  string    `json:"level"`
+	Text   string    `json:"text"`
+	URL    string    `json:"url,omitempty"`
+	Line   int       `json:"line,omitempty"`
+}
+
+// consoleLog - appends console entries of one session to temporary file in log output directory and sends them to viewers
+type consoleLog struct {
+	lock    sync.Mutex
+	name    string
+	file    *os.File
+	enc     *json.Encoder
+	viewers map[chan consoleEntry]struct{}
+	*devtoolsListener
+}
+
+// startConsoleLog - records console of session until it is stopped
+func startConsoleLog(requestId uint64, sid string, devtools string) error {
+	name := filepath.Join(logOutputDir, getTemporaryFileName(logOutputDir, consoleLogFileExtension))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("create console log: %v", err)
+	}
+	cl := &consoleLog{name: name, file: f, enc: json.NewEncoder(f), viewers: make(map[chan consoleEntry]struct{})}
+	cl.devtoolsListener = listenDevtools(requestId, sid, devtools, "CONSOLE_LOG_ERROR", cl.record)
+	consoleLogsLock.Lock()
+	defer consoleLogsLock.Unlock()
+	consoleLogs[sid] = cl
+	return nil
+}
+
+func getConsoleLog(sid string) (*consoleLog, bool) {
+	consoleLogsLock.Lock()
+	defer consoleLogsLock.Unlock()
+	cl, ok := consoleLogs[sid]
+	return cl, ok
+}
+
+// consoleLogFiles - temporary files of running sessions
+func consoleLogFiles() []string {
+	consoleLogsLock.Lock()
+	defer consoleLogsLock.Unlock()
+	var ret []string
+	for _, cl := range consoleLogs {
+		ret = append(ret, cl.name)
+	}
+	return ret
+}
+
+func (cl *consoleLog) record(ctx context.Context, c *cdp.Client) error {
+	consoleAPICalled, err := c.Runtime.ConsoleAPICalled(ctx)
+	if err != nil {
+		return err
+	}
+	defer consoleAPICalled.Close()
+	exceptionThrown, err := c.Runtime.ExceptionThrown(ctx)
+	if err != nil {
+		return err
+	}
+	defer exceptionThrown.Close()
+	entryAdded, err := c.Log.EntryAdded(ctx)
+	if err != nil {
+		return err
+	}
+	defer entryAdded.Close()
+	if err := cdp.Sync(consoleAPICalled, exceptionThrown, entryAdded); err != nil {
+		return err
+	}
+	if err := c.Runtime.Enable(ctx); err != nil {
+		return fmt.Errorf("enable runtime events: %v", err)
+	}
+	if err := c.Log.Enable(ctx); err != nil {
+		return fmt.Errorf("enable log events: %v", err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-consoleAPICalled.Ready():
+			ev, err := consoleAPICalled.Recv()
+			if err != nil {
+				return err
+			}
+			entry := consoleEntry{Time: ev.Timestamp.Time(), Source: "console", Level: ev.Type, Text: consoleText(ev.Args)}
+			if ev.StackTrace != nil && len(ev.StackTrace.CallFrames) > 0 {
+				frame := ev.StackTrace.CallFrames[0]
+				entry.URL, entry.Line = frame.URL, frame.LineNumber+1
+			}
+			cl.add(entry)
+		case <-exceptionThrown.Ready():
+			ev, err := exceptionThrown.Recv()
+			if err != nil {
+				return err
+			}
+			details := ev.ExceptionDetails
+			entry := consoleEntry{Time: ev.Timestamp.Time(), Source: "exception", Level: "error", Text: details.Text, Line: details.LineNumber + 1}
+			if details.Exception != nil && details.Exception.Description != nil {
+				entry.Text = fmt.Sprintf("%s %s", details.Text, *details.Exception.Description)
+			}
+			if details.URL != nil {
+				entry.URL = *details.URL
+			}
+			cl.add(entry)
+		case <-entryAdded.Ready():
+			ev, err := entryAdded.Recv()
+			if err != nil {
+				return err
+			}
+			entry := consoleEntry{Time: ev.Entry.Timestamp.Time(), Source: ev.Entry.Source, Level: ev.Entry.Level, Text: ev.Entry.Text}
+			if ev.Entry.URL != nil {
+				entry.URL = *ev.Entry.URL
+			}
+			if ev.Entry.LineNumber != nil {
+				entry.Line = *ev.Entry.LineNumber + 1
+			}
+			cl.add(entry)
+		}
+	}
+}
+
+// consoleText - console call arguments joined like browser console shows them
+func consoleText(args []runtime.RemoteObject) string {
+	var ret []string
+	for _, arg := range args {
+		var s string
+		switch {
+		case arg.Type == "string" && json.Unmarshal(arg.Value, &s) == nil:
+		case len(arg.Value) > 0:
+			s = string(arg.Value)
+		case arg.UnserializableValue != nil:
+			s = string(*arg.UnserializableValue)
+		case arg.Description != nil:
+			s = *arg.Description
+		default:
+			s = arg.Type
+		}
+		ret = append(ret, s)
+	}
+	return strings.Join(ret, " ")
+}
+
+func (cl *consoleLog) add(entry consoleEntry) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	if cl.file == nil {
+		return
+	}
+	_ = cl.enc.Encode(entry)
+	for viewer := range cl.viewers {
+		select {
+		case viewer <- entry:
+		default:
+		}
+	}
+}
+
+// watch - subscribes to new console entries, channel is closed when session is finished
+func (cl *consoleLog) watch() (<-chan consoleEntry, func()) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	entries := make(chan consoleEntry, consoleViewerBuffer)
+	if cl.file == nil {
+		close(entries)
+		return entries, func() {}
+	}
+	cl.viewers[entries] = struct{}{}
+	return entries, func() {
+		cl.lock.Lock()
+		defer cl.lock.Unlock()
+		delete(cl.viewers, entries)
+	}
+}
+
+func (cl *consoleLog) close() error {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	for viewer := range cl.viewers {
+		close(viewer)
+		delete(cl.viewers, viewer)
+	}
+	err := cl.file.Close()
+	cl.file = nil
+	return err
+}
+
+// saveConsoleLog - stops recording console, renames console log of finished session and notifies listeners
+func saveConsoleLog(e event.Event, sid string) {
+	consoleLogsLock.Lock()
+	cl, ok := consoleLogs[sid]
+	delete(consoleLogs, sid)
+	consoleLogsLock.Unlock()
+	if !ok {
+		return
+	}
+	cl.stop()
+	err := cl.close()
+	newName := filepath.Join(logOutputDir, e.SessionId+consoleLogFileExtension)
+	if err == nil {
+		err = os.Rename(cl.name, newName)
+	}
+	if err != nil {
+		log.Printf("[%d] [CONSOLE_LOG_ERROR] [%s]", e.RequestId, fmt.Sprintf("Failed to save %s to %s: %v", cl.name, newName, err))
+		return
+	}
+	event.FileCreated(event.CreatedFile{
+		Event: e,
+		Name:  newName,
+		Type:  consoleLogFileType,
+	})
+}
+
+// console - streams new console entries of running session as JSON messages over WebSocket
+func console(wsconn *websocket.Conn) {
+	defer wsconn.Close()
+	requestId := serial()
+	sid, _ := splitRequestPath(wsconn.Request().URL.Path)
+	cl, ok := getConsoleLog(sid)
+	if !ok {
+		log.Printf("[%d] [SESSION_NOT_FOUND] [%s]", requestId, sid)
+		return
+	}
+	log.Printf("[%d] [CONSOLE_LOG] [%s]", requestId, sid)
+	entries, stop := cl.watch()
+	defer stop()
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		// Viewer messages are ignored, read fails when viewer disconnects
+		buf := make([]byte, 512)
+		for {
+			if _, err := wsconn.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-disconnected:
+			log.Printf("[%d] [CONSOLE_LOG_DISCONNECTED] [%s]", requestId, sid)
+			return
+		case entry, ok := <-entries:
+			if !ok {
+				log.Printf("[%d] [CONSOLE_LOG_SESSION_FINISHED] [%s]", requestId, sid)
+				return
+			}
+			if err := websocket.JSON.Send(wsconn, entry); err != nil {
+				log.Printf("[%d] [CONSOLE_LOG_DISCONNECTED] [%s] [%v]", requestId, sid, err)
+				return
+			}
+		}
+	}
+}
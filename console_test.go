@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+var consoleEvents = []string{
+	`{"method":"Runtime.consoleAPICalled","params":{"type":"log","args":[{"type":"string","value":"loaded"},{"type":"number","value":42},{"type":"object","description":"Object"}],"executionContextId":1,"timestamp":1700000000000,"stackTrace":{"callFrames":[{"functionName":"","scriptId":"1","url":"http://example.com/app.js","lineNumber":9,"columnNumber":0}]}}}`,
+	`{"method":"Runtime.exceptionThrown","params":{"timestamp":1700000001000,"exceptionDetails":{"exceptionId":1,"text":"Uncaught","lineNumber":19,"columnNumber":5,"url":"http://example.com/app.js","exception":{"type":"object","description":"TypeError: x is undefined"}}}}`,
+	`{"method":"Log.entryAdded","params":{"entry":{"source":"network","level":"error","text":"Failed to load resource: 404","timestamp":1700000002000,"url":"http://example.com/missing.png"}}}`,
+}
+
+func TestConsoleLog(t *testing.T) {
+	events := make(chan string, len(consoleEvents))
+	selenium := Selenium()
+	mux := http.NewServeMux()
+	mux.Handle("/session", selenium)
+	mux.Handle("/session/", selenium)
+	mux.Handle("/", devtoolsStub("Log.enable", events))
+	manager = &HTTPTest{Handler: mux}
+
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"desiredCapabilities":{"enableConsoleLog":true}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var sess map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sess))
+	sid := sess["sessionId"]
+
+	cl, ok := getConsoleLog(sid)
+	assert.True(t, ok)
+	u := "ws://" + strings.TrimPrefix(srv.URL, "http://") + "/wd/hub/session/" + sid + "/aerokube/console"
+	ws, err := websocket.Dial(u, "", "http://localhost/")
+	assert.NoError(t, err)
+	defer ws.Close()
+	waitFor(t, func() bool {
+		cl.lock.Lock()
+		defer cl.lock.Unlock()
+		return len(cl.viewers) == 1
+	})
+
+	for _, e := range consoleEvents {
+		events <- e
+	}
+	var tail []consoleEntry
+	for range consoleEvents {
+		var entry consoleEntry
+		assert.NoError(t, websocket.JSON.Receive(ws, &entry))
+		tail = append(tail, entry)
+	}
+	assert.Equal(t, tail[0].Source, "console")
+	assert.Equal(t, tail[0].Level, "log")
+	assert.Equal(t, tail[0].Text, "loaded 42 Object")
+	assert.Equal(t, tail[0].URL, "http://example.com/app.js")
+	assert.Equal(t, tail[0].Line, 10)
+	assert.Equal(t, tail[0].Time.Unix(), int64(1700000000))
+	assert.Equal(t, tail[1].Source, "exception")
+	assert.Equal(t, tail[1].Level, "error")
+	assert.Equal(t, tail[1].Text, "Uncaught TypeError: x is undefined")
+	assert.Equal(t, tail[1].Line, 20)
+	assert.Equal(t, tail[2].Source, "network")
+	assert.Equal(t, tail[2].Text, "Failed to load resource: 404")
+
+	req, _ := http.NewRequest(http.MethodDelete, With(srv.URL).Path("/wd/hub/session/"+sid), nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var entry consoleEntry
+	assert.Error(t, websocket.JSON.Receive(ws, &entry))
+
+	name := filepath.Join(logOutputDir, preprocessSessionId(sid)+consoleLogFileExtension)
+	waitFor(t, func() bool {
+		_, err := os.Stat(name)
+		return err == nil
+	})
+	defer os.Remove(name)
+	assert.Equal(t, fileTypeOf(name), consoleLogFileType)
+	f, err := os.Open(name)
+	assert.NoError(t, err)
+	defer f.Close()
+	var saved []consoleEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e consoleEntry
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		saved = append(saved, e)
+	}
+	assert.Equal(t, saved, tail)
+}
+
+func TestConsoleTailUnknownSession(t *testing.T) {
+	u := "ws://" + strings.TrimPrefix(srv.URL, "http://") + paths.Console + "missing"
+	ws, err := websocket.Dial(u, "", "http://localhost/")
+	assert.NoError(t, err)
+	defer ws.Close()
+	var entry consoleEntry
+	assert.Error(t, websocket.JSON.Receive(ws, &entry))
+}
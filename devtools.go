@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aerokube/selenoid/jsonerror"
+	"github.com/aerokube/selenoid/session"
+	"github.com/gorilla/websocket"
+	"github.com/mafredri/cdp"
+	"github.com/mafredri/cdp/rpcc"
+)
+
+const (
+	// Time to process events already sent by browser when session is stopped
+	devtoolsStopTimeout = 5 * time.Second
+	// JSON-RPC error code returned by browser for unknown methods
+	devtoolsMethodNotAllowed = -32601
+)
+
+// devtoolsListener - background connection to DevTools of running session
+type devtoolsListener struct {
+	cancel func()
+	done   chan struct{}
+}
+
+// listenDevtools - connects to session DevTools and calls listen until session is stopped, errors are logged with given status
+func listenDevtools(requestId uint64, sid string, devtools string, status string, listen func(ctx context.Context, c *cdp.Client) error) *devtoolsListener {
+	ctx, cancel := context.WithCancel(context.Background())
+	dl := &devtoolsListener{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(dl.done)
+		conn, err := rpcc.DialContext(ctx, "ws://"+devtools+slash)
+		if err != nil {
+			log.Printf("[%d] [%s] [%s] [Failed to connect to devtools: %v]", requestId, status, sid, err)
+			return
+		}
+		defer conn.Close()
+		err = listen(ctx, cdp.NewClient(conn))
+		// Connection is closed when browser is stopped before listener
+		if err != nil && ctx.Err() == nil && conn.Context().Err() == nil {
+			log.Printf("[%d] [%s] [%s] [%v]", requestId, status, sid, err)
+		}
+	}()
+	return dl
+}
+
+// stop - disconnects from DevTools waiting for already received events to be processed
+func (dl *devtoolsListener) stop() {
+	dl.cancel()
+	select {
+	case <-dl.done:
+	case <-time.After(devtoolsStopTimeout):
+	}
+}
+
+var devtoolsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(_ *http.Request) bool {
+		return true
+	},
+}
+
+func devtoolsHost(sess *session.Session) string {
+	return sess.HostPort.Devtools
+}
+
+// devtools - proxies DevTools requests to browser, WebSocket traffic is filtered when allowed methods are configured
+func devtools(w http.ResponseWriter, r *http.Request) {
+	sid, remainingPath := splitRequestPath(r.URL.Path)
+	requestId := serial()
+	if len(devtoolsAllowlist) > 0 && !websocket.IsWebSocketUpgrade(r) && !isDevtoolsTargetList(remainingPath) {
+		// Endpoints like /json/close/<target-id> or /json/new change browser state bypassing allowed methods
+		log.Printf("[%d] [DEVTOOLS_BLOCKED] [%s] [%s]", requestId, sid, remainingPath)
+		http.Error(w, fmt.Sprintf("Endpoint %s is not allowed", remainingPath), http.StatusForbidden)
+		return
+	}
+	if len(devtoolsAllowlist) == 0 || !websocket.IsWebSocketUpgrade(r) {
+		reverseProxy(devtoolsHost, "DEVTOOLS", func(resp *http.Response) error {
+			return rewriteDevtoolsURLs(resp, remainingPath, webSocketScheme(r), r.Host, sid)
+		})(w, r)
+		return
+	}
+	sess, ok := sessions.Get(sid)
+	if !ok {
+		jsonerror.InvalidSessionID(fmt.Errorf("unknown session %s", sid)).Encode(w)
+		log.Printf("[%d] [SESSION_NOT_FOUND] [%s]", requestId, sid)
+		return
+	}
+	resetTimeout(requestId, r, sid, sess)
+	log.Printf("[%d] [DEVTOOLS] [%s] [%s]", requestId, sid, remainingPath)
+	proxyCDP(requestId, w, r, sid, (&url.URL{Scheme: "ws", Host: devtoolsHost(sess), Path: remainingPath}).String())
+}
+
+// devtoolsAllowed - whether CDP method matches one of allowed methods, Domain.* matches all methods of domain
+func devtoolsAllowed(method string) bool {
+	for _, allowed := range devtoolsAllowlist {
+		if allowed == "*" || allowed == method {
+			return true
+		}
+		if domain, ok := strings.CutSuffix(allowed, ".*"); ok && strings.HasPrefix(method, domain+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyCDP - forwards CDP messages between client and browser answering commands not in allowlist with an error
+func proxyCDP(requestId uint64, w http.ResponseWriter, r *http.Request, sid string, backendUrl string) {
+	backend, resp, err := websocket.DefaultDialer.DialContext(r.Context(), backendUrl, nil)
+	if err != nil {
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		log.Printf("[%d] [DEVTOOLS_ERROR] [%s] [%v]", requestId, sid, err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer backend.Close()
+	client, err := devtoolsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("[%d] [DEVTOOLS_ERROR] [%s] [%v]", requestId, sid, err)
+		return
+	}
+	defer client.Close()
+	var clientLock sync.Mutex
+	writeClient := func(messageType int, data []byte) error {
+		clientLock.Lock()
+		defer clientLock.Unlock()
+		return client.WriteMessage(messageType, data)
+	}
+	go func() {
+		// Closing client connection stops reading commands below
+		defer client.Close()
+		for {
+			messageType, data, err := backend.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := writeClient(messageType, data); err != nil {
+				return
+			}
+		}
+	}()
+	usage := make(map[string]int)
+	for {
+		messageType, data, err := client.ReadMessage()
+		if err != nil {
+			break
+		}
+		var command struct {
+			ID        json.RawMessage `json:"id"`
+			Method    string          `json:"method"`
+			SessionID string          `json:"sessionId,omitempty"`
+		}
+		if err := json.Unmarshal(data, &command); err != nil || command.Method == "" {
+			log.Printf("[%d] [DEVTOOLS_BLOCKED] [%s] [Invalid command]", requestId, sid)
+			continue
+		}
+		if !devtoolsAllowed(command.Method) {
+			log.Printf("[%d] [DEVTOOLS_BLOCKED] [%s] [%s]", requestId, sid, command.Method)
+			reply, _ := json.Marshal(struct {
+				ID        json.RawMessage    `json:"id"`
+				Error     rpcc.ResponseError `json:"error"`
+				SessionID string             `json:"sessionId,omitempty"`
+			}{command.ID, rpcc.ResponseError{Code: devtoolsMethodNotAllowed, Message: fmt.Sprintf("Method %s is not allowed", command.Method)}, command.SessionID})
+			if err := writeClient(websocket.TextMessage, reply); err != nil {
+				break
+			}
+			continue
+		}
+		usage[command.Method]++
+		if err := backend.WriteMessage(messageType, data); err != nil {
+			break
+		}
+	}
+	log.Printf("[%d] [DEVTOOLS_SESSION_CLOSED] [%s] [%s]", requestId, sid, formatUsage(usage))
+}
+
+// formatUsage - number of calls of every CDP method sorted by method
+func formatUsage(usage map[string]int) string {
+	methods := make([]string, 0, len(usage))
+	for method := range usage {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	var ret []string
+	for _, method := range methods {
+		ret = append(ret, fmt.Sprintf("%s=%d", method, usage[method]))
+	}
+	return strings.Join(ret, ", ")
+}
+
+// isDevtoolsTargetList - whether path is one of read-only HTTP endpoints listing browser targets
+func isDevtoolsTargetList(remainingPath string) bool {
+	switch strings.TrimSuffix(remainingPath, slash) {
+	case "/json", "/json/list", "/json/version":
+		return true
+	}
+	return false
+}
+
+// rewriteDevtoolsURLs - makes WebSocket URLs returned by browser target list point to Selenoid
+func rewriteDevtoolsURLs(resp *http.Response, remainingPath string, scheme string, host string, sid string) error {
+	if !isDevtoolsTargetList(remainingPath) || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	data, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	var body interface{}
+	if json.Unmarshal(data, &body) == nil {
+		var targets []interface{}
+		switch value := body.(type) {
+		case []interface{}:
+			targets = value
+		case map[string]interface{}:
+			targets = []interface{}{value}
+		}
+		for _, t := range targets {
+			target, ok := t.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if ws, ok := target["webSocketDebuggerUrl"].(string); ok {
+				target["webSocketDebuggerUrl"] = devtoolsURL(ws, scheme, host, sid)
+			}
+			if frontend, ok := target["devtoolsFrontendUrl"].(string); ok {
+				if u, err := url.Parse(frontend); err == nil && u.Query().Get("ws") != "" {
+					// Frontend takes address of secure WebSocket from wss parameter
+					query := u.Query()
+					ws := query.Get("ws")
+					query.Del("ws")
+					query.Set(scheme, strings.TrimPrefix(devtoolsURL("ws://"+ws, scheme, host, sid), scheme+"://"))
+					u.RawQuery = query.Encode()
+					target["devtoolsFrontendUrl"] = u.String()
+				}
+			}
+		}
+		if rewritten, err := json.Marshal(body); err == nil {
+			data = rewritten
+		}
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	return nil
+}
+
+// devtoolsURL - Selenoid URL for browser DevTools WebSocket URL like ws://127.0.0.1:9222/devtools/page/<target-id>
+func devtoolsURL(ws string, scheme string, host string, sid string) string {
+	u, err := url.Parse(ws)
+	if err != nil {
+		return ws
+	}
+	p := strings.TrimPrefix(u.Path, strings.TrimSuffix(paths.Devtools, slash))
+	if strings.HasPrefix(p, "/browser") {
+		p = "/browser"
+	}
+	return (&url.URL{Scheme: scheme, Host: host, Path: paths.Devtools + sid + p}).String()
+}
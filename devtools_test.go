@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mafredri/cdp"
+	"github.com/mafredri/cdp/rpcc"
+	assert "github.com/stretchr/testify/require"
+)
+
+func withDevtoolsAllowlist(methods ...string) func() {
+	old := devtoolsAllowlist
+	devtoolsAllowlist = methods
+	return func() {
+		devtoolsAllowlist = old
+	}
+}
+
+func createSession(t *testing.T) string {
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte("{}")))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var sess map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sess))
+	return sess["sessionId"]
+}
+
+func TestDevtoolsAllowed(t *testing.T) {
+	defer withDevtoolsAllowlist("Page.*", "Runtime.evaluate")()
+	assert.True(t, devtoolsAllowed("Page.navigate"))
+	assert.True(t, devtoolsAllowed("Runtime.evaluate"))
+	assert.False(t, devtoolsAllowed("Runtime.enable"))
+	assert.False(t, devtoolsAllowed("PageX.navigate"))
+	assert.False(t, devtoolsAllowed("Browser.close"))
+	devtoolsAllowlist = []string{"*"}
+	assert.True(t, devtoolsAllowed("Browser.close"))
+}
+
+func TestDevtoolsAllowlist(t *testing.T) {
+	defer withDevtoolsAllowlist("Page.*")()
+	manager = &HTTPTest{Handler: Selenium()}
+	sid := createSession(t)
+	defer func() {
+		sessions.Remove(sid)
+		queue.Release()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, err := rpcc.DialContext(ctx, fmt.Sprintf("ws://%s/devtools/%s", srv.Listener.Addr().String(), sid))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	c := cdp.NewClient(conn)
+	assert.NoError(t, c.Page.Enable(ctx))
+	err = c.Browser.Close(ctx)
+	var rspErr *rpcc.ResponseError
+	assert.True(t, errors.As(err, &rspErr))
+	assert.Equal(t, rspErr.Code, int64(devtoolsMethodNotAllowed))
+	assert.Equal(t, rspErr.Message, "Method Browser.close is not allowed")
+	assert.NoError(t, c.Page.Enable(ctx))
+}
+
+func TestDevtoolsTargetURLs(t *testing.T) {
+	selenium := Selenium()
+	mux := http.NewServeMux()
+	mux.Handle("/session", selenium)
+	mux.Handle("/session/", selenium)
+	mux.HandleFunc("/json/version", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"Browser":"Chrome/120.0","webSocketDebuggerUrl":"ws://127.0.0.1:9222/devtools/browser/b4b5"}`))
+	})
+	mux.HandleFunc("/json/list", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[{"id":"A1","type":"page","devtoolsFrontendUrl":"/devtools/inspector.html?ws=127.0.0.1:9222/devtools/page/A1","webSocketDebuggerUrl":"ws://127.0.0.1:9222/devtools/page/A1"}]`))
+	})
+	manager = &HTTPTest{Handler: mux}
+	sid := createSession(t)
+	defer func() {
+		sessions.Remove(sid)
+		queue.Release()
+	}()
+	host := srv.Listener.Addr().String()
+
+	rsp, err := http.Get(With(srv.URL).Path("/devtools/" + sid + "/json/version"))
+	assert.NoError(t, err)
+	var version map[string]string
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&version))
+	assert.Equal(t, version["Browser"], "Chrome/120.0")
+	assert.Equal(t, version["webSocketDebuggerUrl"], "ws://"+host+"/devtools/"+sid+"/browser")
+
+	rsp, err = http.Get(With(srv.URL).Path("/devtools/" + sid + "/json/list"))
+	assert.NoError(t, err)
+	var targets []map[string]string
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&targets))
+	assert.Len(t, targets, 1)
+	assert.Equal(t, targets[0]["webSocketDebuggerUrl"], "ws://"+host+"/devtools/"+sid+"/page/A1")
+	frontend, err := url.Parse(targets[0]["devtoolsFrontendUrl"])
+	assert.NoError(t, err)
+	assert.Equal(t, frontend.Path, "/devtools/inspector.html")
+	assert.Equal(t, frontend.Query().Get("ws"), host+"/devtools/"+sid+"/page/A1")
+
+	req, _ := http.NewRequest(http.MethodGet, With(srv.URL).Path("/devtools/"+sid+"/json/list"), nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rsp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&targets))
+	assert.Equal(t, targets[0]["webSocketDebuggerUrl"], "wss://"+host+"/devtools/"+sid+"/page/A1")
+	frontend, err = url.Parse(targets[0]["devtoolsFrontendUrl"])
+	assert.NoError(t, err)
+	assert.Empty(t, frontend.Query().Get("ws"))
+	assert.Equal(t, frontend.Query().Get("wss"), host+"/devtools/"+sid+"/page/A1")
+}
+
+func TestDevtoolsAllowlistBlocksEndpoints(t *testing.T) {
+	defer withDevtoolsAllowlist("Page.*")()
+	selenium := Selenium()
+	mux := http.NewServeMux()
+	mux.Handle("/session", selenium)
+	mux.Handle("/session/", selenium)
+	closed := false
+	mux.HandleFunc("/json/close/", func(w http.ResponseWriter, _ *http.Request) {
+		closed = true
+		_, _ = w.Write([]byte("Target is closing"))
+	})
+	mux.HandleFunc("/json/version", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"Browser":"Chrome/120.0"}`))
+	})
+	manager = &HTTPTest{Handler: mux}
+	sid := createSession(t)
+	defer func() {
+		sessions.Remove(sid)
+		queue.Release()
+	}()
+
+	for _, path := range []string{"/json/close/A1", "/json/new?about:blank", "/json/activate/A1"} {
+		req, _ := http.NewRequest(http.MethodPut, With(srv.URL).Path("/devtools/"+sid+path), nil)
+		rsp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, rsp.StatusCode, http.StatusForbidden)
+	}
+	assert.False(t, closed)
+
+	rsp, err := http.Get(With(srv.URL).Path("/devtools/" + sid + "/json/version"))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aerokube/selenoid/session"
+	"github.com/docker/go-units"
+)
+
+const (
+	lowDiskSpaceReject  = "reject"
+	lowDiskSpaceDisable = "disable"
+)
+
+// dirSpace - free disk space in output directory
+type dirSpace struct {
+	Dir  string `json:"dir"`
+	Free uint64 `json:"free"`
+	Low  bool   `json:"low"`
+}
+
+func checkDirSpace(dir string) (dirSpace, bool) {
+	free, err := freeSpace(dir)
+	if err != nil {
+		log.Printf("[-] [DISK_SPACE_ERROR] [%s] [%v]", dir, err)
+		return dirSpace{}, false
+	}
+	return dirSpace{Dir: dir, Free: free, Low: free < uint64(minFreeSpace)}, true
+}
+
+// diskSpace - free disk space in video and log output directories when disk space guard is enabled
+func diskSpace() []dirSpace {
+	if minFreeSpace <= 0 {
+		return nil
+	}
+	var ret []dirSpace
+	for _, dir := range outputDirs() {
+		if ds, ok := checkDirSpace(dir); ok {
+			ret = append(ret, ds)
+		}
+	}
+	return ret
+}
+
+func outputDirs() []string {
+	var dirs []string
+	if !disableDocker {
+		dirs = append(dirs, videoOutputDir)
+	}
+	if logOutputDir != "" && logOutputDir != videoOutputDir {
+		dirs = append(dirs, logOutputDir)
+	}
+	return dirs
+}
+
+// guardDiskSpace - reject session or disable recording when output directory is low on disk space, returns warnings for disabled recording
+func guardDiskSpace(caps *session.Caps, saveLog *bool) ([]string, error) {
+	if minFreeSpace <= 0 {
+		return nil, nil
+	}
+	var warnings []string
+	check := func(dir string, what string) (bool, error) {
+		ds, ok := checkDirSpace(dir)
+		if !ok || !ds.Low {
+			return false, nil
+		}
+		msg := fmt.Sprintf("not enough free disk space for %s: %s available, %s required", what, units.BytesSize(float64(ds.Free)), units.BytesSize(float64(minFreeSpace)))
+		if lowDiskSpaceAction == lowDiskSpaceReject {
+			return false, errors.New(msg)
+		}
+		warnings = append(warnings, fmt.Sprintf("%s recording disabled: %s", what, msg))
+		return true, nil
+	}
+	if caps.Video && !disableDocker {
+		disabled, err := check(videoOutputDir, "video")
+		if err != nil {
+			return nil, err
+		}
+		if disabled {
+			caps.Video = false
+		}
+	}
+	if *saveLog {
+		disabled, err := check(logOutputDir, "log")
+		if err != nil {
+			return nil, err
+		}
+		if disabled {
+			*saveLog = false
+			caps.Log = false
+		}
+	}
+	if caps.CommandLog && logOutputDir != "" {
+		disabled, err := check(logOutputDir, "command log")
+		if err != nil {
+			return nil, err
+		}
+		if disabled {
+			caps.CommandLog = false
+		}
+	}
+	if caps.HAR && logOutputDir != "" {
+		disabled, err := check(logOutputDir, "HAR")
+		if err != nil {
+			return nil, err
+		}
+		if disabled {
+			caps.HAR = false
+		}
+	}
+	if caps.ConsoleLog && logOutputDir != "" {
+		disabled, err := check(logOutputDir, "console log")
+		if err != nil {
+			return nil, err
+		}
+		if disabled {
+			caps.ConsoleLog = false
+		}
+	}
+	if caps.VNCRecording {
+		disabled, err := check(videoOutputDir, "VNC")
+		if err != nil {
+			return nil, err
+		}
+		if disabled {
+			caps.VNCRecording = false
+		}
+	}
+	return warnings, nil
+}
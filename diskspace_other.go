@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !openbsd && !windows
+// +build !linux,!darwin,!freebsd,!dragonfly,!openbsd,!windows
+
+package main
+
+import "errors"
+
+func freeSpace(_ string) (uint64, error) {
+	return 0, errors.New("free disk space is not supported on this platform")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func withMinFreeSpace(size byteSize, action string) func() {
+	oldSize, oldAction := minFreeSpace, lowDiskSpaceAction
+	minFreeSpace, lowDiskSpaceAction = size, action
+	return func() {
+		minFreeSpace, lowDiskSpaceAction = oldSize, oldAction
+	}
+}
+
+func TestLowDiskSpaceReject(t *testing.T) {
+	defer withMinFreeSpace(1<<62, lowDiskSpaceReject)()
+	manager = &HTTPTest{Handler: Selenium()}
+
+	rsp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"desiredCapabilities": {"enableVideo": true}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusInternalServerError)
+	var e struct {
+		Value struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		} `json:"value"`
+	}
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&e))
+	assert.Equal(t, e.Value.Error, "session not created")
+	assert.Contains(t, e.Value.Message, "not enough free disk space for video")
+	assert.Equal(t, queue.Used(), 0)
+}
+
+func TestLowDiskSpaceDisableRecording(t *testing.T) {
+	defer withMinFreeSpace(1<<62, lowDiskSpaceDisable)()
+	manager = &HTTPTest{Handler: Selenium(func(input map[string]interface{}) {
+		input["value"] = map[string]interface{}{
+			"sessionId":    input["sessionId"],
+			"capabilities": map[string]interface{}{"browserVersion": "some-version"},
+		}
+		delete(input, "sessionId")
+	})}
+
+	rsp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"desiredCapabilities": {"enableVideo": true, "enableLog": true}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	var sess struct {
+		Value struct {
+			SessionId    string `json:"sessionId"`
+			Capabilities struct {
+				Warnings []string `json:"selenoid:warnings"`
+			} `json:"capabilities"`
+		} `json:"value"`
+	}
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&sess))
+	warnings := sess.Value.Capabilities.Warnings
+	assert.Len(t, warnings, 2)
+	assert.Contains(t, warnings[0], "video recording disabled")
+	assert.Contains(t, warnings[1], "log recording disabled")
+
+	s, ok := sessions.Get(sess.Value.SessionId)
+	assert.True(t, ok)
+	assert.False(t, s.Caps.Video)
+	assert.False(t, s.Caps.Log)
+	sessions.Remove(sess.Value.SessionId)
+	queue.Release()
+}
+
+func TestPingDiskSpace(t *testing.T) {
+	defer withMinFreeSpace(1<<62, lowDiskSpaceReject)()
+
+	rsp, err := http.Get(With(srv.URL).Path("/ping"))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	var data struct {
+		DiskSpace []dirSpace `json:"diskSpace"`
+	}
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&data))
+	assert.Len(t, data.DiskSpace, 2)
+	assert.Equal(t, data.DiskSpace[0].Dir, videoOutputDir)
+	assert.True(t, data.DiskSpace[0].Low)
+}
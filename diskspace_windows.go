@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "errors"
+
+func freeSpace(_ string) (uint64, error) {
+	return 0, errors.New("free disk space is not supported on Windows")
+}
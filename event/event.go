@@ -1,10 +1,19 @@
 package event
 
-import "github.com/aerokube/selenoid/session"
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/aerokube/selenoid/session"
+)
 
 var (
 	fileCreatedListeners    []FileCreatedListener
+	fileDeletedListeners    []FileDeletedListener
 	sessionStoppedListeners []SessionStoppedListener
+
+	running    sync.WaitGroup
+	numRunning atomic.Int64
 )
 
 type InitRequired interface {
@@ -27,6 +36,18 @@ type FileCreatedListener interface {
 	OnFileCreated(createdFile CreatedFile)
 }
 
+// DeletedFile - file removed from output directory, session is not always known
+type DeletedFile struct {
+	Event
+	Name   string
+	Type   string
+	Reason string
+}
+
+type FileDeletedListener interface {
+	OnFileDeleted(deletedFile DeletedFile)
+}
+
 type StoppedSession struct {
 	Event
 }
@@ -37,10 +58,30 @@ type SessionStoppedListener interface {
 
 func FileCreated(createdFile CreatedFile) {
 	for _, l := range fileCreatedListeners {
-		go l.OnFileCreated(createdFile)
+		run(func() { l.OnFileCreated(createdFile) })
 	}
 }
 
+func run(fn func()) {
+	running.Add(1)
+	numRunning.Add(1)
+	go func() {
+		defer running.Done()
+		defer numRunning.Add(-1)
+		fn()
+	}()
+}
+
+// Running - number of listeners still processing events
+func Running() int64 {
+	return numRunning.Load()
+}
+
+// Wait - block until all listeners finish processing events
+func Wait() {
+	running.Wait()
+}
+
 func InitIfNeeded(listener interface{}) {
 	if l, ok := listener.(InitRequired); ok {
 		l.Init()
@@ -52,9 +93,20 @@ func AddFileCreatedListener(listener FileCreatedListener) {
 	fileCreatedListeners = append(fileCreatedListeners, listener)
 }
 
+func FileDeleted(deletedFile DeletedFile) {
+	for _, l := range fileDeletedListeners {
+		run(func() { l.OnFileDeleted(deletedFile) })
+	}
+}
+
+func AddFileDeletedListener(listener FileDeletedListener) {
+	InitIfNeeded(listener)
+	fileDeletedListeners = append(fileDeletedListeners, listener)
+}
+
 func SessionStopped(stoppedSession StoppedSession) {
 	for _, l := range sessionStoppedListeners {
-		go l.OnSessionStopped(stoppedSession)
+		run(func() { l.OnSessionStopped(stoppedSession) })
 	}
 }
 
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+	"github.com/mafredri/cdp"
+	"github.com/mafredri/cdp/protocol/network"
+)
+
+const harVersion = "1.2"
+
+var (
+	harRecordersLock sync.Mutex
+	harRecorders     = make(map[string]*harRecorder)
+)
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Pages   []struct{}  `json:"pages"`
+	Entries []*harEntry `json:"entries"`
+	Comment string      `json:"comment,omitempty"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	ResourceType    string      `json:"_resourceType,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+
+	started, responded network.MonotonicTime
+}
+
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []harHeader `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	QueryString []harHeader `json:"queryString"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []harHeader `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	Content     harContent  `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harRecorder - collects network requests of one session from browser DevTools,
+// requests made after harMaxEntries are only counted as dropped
+type harRecorder struct {
+	lock    sync.Mutex
+	entries []*harEntry
+	dropped int
+	pending map[network.RequestID]*harEntry
+	*devtoolsListener
+}
+
+// startHAR - records network events of session until it is stopped
+func startHAR(requestId uint64, sid string, devtools string) {
+	hr := &harRecorder{pending: make(map[network.RequestID]*harEntry)}
+	hr.devtoolsListener = listenDevtools(requestId, sid, devtools, "HAR_ERROR", hr.record)
+	harRecordersLock.Lock()
+	defer harRecordersLock.Unlock()
+	harRecorders[sid] = hr
+}
+
+func getHARRecorder(sid string) (*harRecorder, bool) {
+	harRecordersLock.Lock()
+	defer harRecordersLock.Unlock()
+	hr, ok := harRecorders[sid]
+	return hr, ok
+}
+
+func (hr *harRecorder) record(ctx context.Context, c *cdp.Client) error {
+	requestWillBeSent, err := c.Network.RequestWillBeSent(ctx)
+	if err != nil {
+		return err
+	}
+	defer requestWillBeSent.Close()
+	responseReceived, err := c.Network.ResponseReceived(ctx)
+	if err != nil {
+		return err
+	}
+	defer responseReceived.Close()
+	loadingFinished, err := c.Network.LoadingFinished(ctx)
+	if err != nil {
+		return err
+	}
+	defer loadingFinished.Close()
+	loadingFailed, err := c.Network.LoadingFailed(ctx)
+	if err != nil {
+		return err
+	}
+	defer loadingFailed.Close()
+	// Events of different types have to be received in order they were sent
+	if err := cdp.Sync(requestWillBeSent, responseReceived, loadingFinished, loadingFailed); err != nil {
+		return err
+	}
+	if err := c.Network.Enable(ctx, nil); err != nil {
+		return fmt.Errorf("enable network events: %v", err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-requestWillBeSent.Ready():
+			ev, err := requestWillBeSent.Recv()
+			if err != nil {
+				return err
+			}
+			hr.onRequest(ev)
+		case <-responseReceived.Ready():
+			ev, err := responseReceived.Recv()
+			if err != nil {
+				return err
+			}
+			hr.onResponse(ev.RequestID, ev.Timestamp, &ev.Response, string(ev.Type))
+		case <-loadingFinished.Ready():
+			ev, err := loadingFinished.Recv()
+			if err != nil {
+				return err
+			}
+			hr.finish(ev.RequestID, ev.Timestamp, int(ev.EncodedDataLength), "")
+		case <-loadingFailed.Ready():
+			ev, err := loadingFailed.Recv()
+			if err != nil {
+				return err
+			}
+			hr.finish(ev.RequestID, ev.Timestamp, 0, ev.ErrorText)
+		}
+	}
+}
+
+func (hr *harRecorder) onRequest(ev *network.RequestWillBeSentReply) {
+	// Redirects are reported as new request with the same identifier
+	if ev.RedirectResponse != nil {
+		hr.onResponse(ev.RequestID, ev.Timestamp, ev.RedirectResponse, string(ev.Type))
+		hr.finish(ev.RequestID, ev.Timestamp, 0, "")
+	}
+	entry := &harEntry{
+		StartedDateTime: ev.WallTime.Time(),
+		Request: harRequest{
+			Method:      ev.Request.Method,
+			URL:         ev.Request.URL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harHeader{},
+			Headers:     harHeaders(ev.Request.Headers),
+			QueryString: harQueryString(ev.Request.URL),
+			HeadersSize: -1,
+		},
+		Response: harResponse{
+			Cookies:     []harHeader{},
+			Headers:     []harHeader{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		ResourceType: string(ev.Type),
+		started:      ev.Timestamp,
+	}
+	if ev.Request.PostData != nil {
+		entry.Request.BodySize = len(*ev.Request.PostData)
+	}
+	hr.lock.Lock()
+	defer hr.lock.Unlock()
+	if harMaxEntries > 0 && len(hr.entries) >= harMaxEntries {
+		hr.dropped++
+		return
+	}
+	hr.pending[ev.RequestID] = entry
+	hr.entries = append(hr.entries, entry)
+}
+
+func (hr *harRecorder) onResponse(id network.RequestID, timestamp network.MonotonicTime, rsp *network.Response, resourceType string) {
+	hr.lock.Lock()
+	defer hr.lock.Unlock()
+	entry, ok := hr.pending[id]
+	if !ok {
+		return
+	}
+	entry.responded = timestamp
+	entry.Response.Status = rsp.Status
+	entry.Response.StatusText = rsp.StatusText
+	entry.Response.Headers = harHeaders(rsp.Headers)
+	entry.Response.Content.MimeType = rsp.MimeType
+	for _, h := range entry.Response.Headers {
+		if strings.EqualFold(h.Name, "Location") {
+			entry.Response.RedirectURL = h.Value
+		}
+	}
+	if rsp.Protocol != nil && *rsp.Protocol != "" {
+		entry.Request.HTTPVersion = *rsp.Protocol
+		entry.Response.HTTPVersion = *rsp.Protocol
+	} else {
+		entry.Response.HTTPVersion = entry.Request.HTTPVersion
+	}
+	if rsp.RemoteIPAddress != nil {
+		entry.ServerIPAddress = *rsp.RemoteIPAddress
+	}
+	if resourceType != "" {
+		entry.ResourceType = resourceType
+	}
+}
+
+func (hr *harRecorder) finish(id network.RequestID, timestamp network.MonotonicTime, size int, errorText string) {
+	hr.lock.Lock()
+	defer hr.lock.Unlock()
+	entry, ok := hr.pending[id]
+	if !ok {
+		return
+	}
+	delete(hr.pending, id)
+	entry.Error = errorText
+	if errorText == "" {
+		entry.Response.BodySize = size
+		entry.Response.Content.Size = size
+	}
+	responded := entry.responded
+	if responded == 0 {
+		responded = timestamp
+	}
+	entry.Timings.Wait = milliseconds(responded - entry.started)
+	entry.Timings.Receive = milliseconds(timestamp - responded)
+	entry.Time = entry.Timings.Send + entry.Timings.Wait + entry.Timings.Receive
+}
+
+func milliseconds(t network.MonotonicTime) float64 {
+	return max(float64(t)*1000, 0)
+}
+
+// harHeaders - sorted header list with values of secret-looking headers redacted
+func harHeaders(headers network.Headers) []harHeader {
+	var values map[string]interface{}
+	_ = json.Unmarshal(headers, &values)
+	ret := []harHeader{}
+	for name, value := range values {
+		v := fmt.Sprint(value)
+		if isSecretKey(name, nil) {
+			v = redacted
+		}
+		ret = append(ret, harHeader{Name: name, Value: v})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+func harQueryString(rawURL string) []harHeader {
+	ret := []harHeader{}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ret
+	}
+	for name, values := range u.Query() {
+		for _, v := range values {
+			ret = append(ret, harHeader{Name: name, Value: v})
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// write - saves recorded entries, has to be called after recording is stopped
+func (hr *harRecorder) write(name string) (int, error) {
+	hr.lock.Lock()
+	entries, dropped := hr.entries, hr.dropped
+	hr.entries = nil
+	hr.lock.Unlock()
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].started < entries[j].started
+	})
+	har := harFile{Log: harLog{
+		Version: harVersion,
+		Creator: harCreator{Name: "Selenoid", Version: gitRevision},
+		Pages:   []struct{}{},
+		Entries: entries,
+	}}
+	if har.Log.Entries == nil {
+		har.Log.Entries = []*harEntry{}
+	}
+	if dropped > 0 {
+		har.Log.Comment = fmt.Sprintf("%d requests were dropped after reaching the limit of %d entries", dropped, len(entries))
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return dropped, err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(har)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return dropped, err
+}
+
+// saveHAR - stops recording network events and saves them to log output directory
+func saveHAR(e event.Event, sid string) {
+	harRecordersLock.Lock()
+	hr, ok := harRecorders[sid]
+	delete(harRecorders, sid)
+	harRecordersLock.Unlock()
+	if !ok {
+		return
+	}
+	hr.stop()
+	name := filepath.Join(logOutputDir, e.SessionId+harFileExtension)
+	dropped, err := hr.write(name)
+	if err != nil {
+		log.Printf("[%d] [HAR_ERROR] [%s]", e.RequestId, fmt.Sprintf("Failed to save %s: %v", name, err))
+		return
+	}
+	if dropped > 0 {
+		log.Printf("[%d] [HAR_ENTRIES_DROPPED] [%s] [%d]", e.RequestId, name, dropped)
+	}
+	event.FileCreated(event.CreatedFile{
+		Event: e,
+		Name:  name,
+		Type:  harFileType,
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mafredri/cdp/protocol/network"
+	assert "github.com/stretchr/testify/require"
+)
+
+var harEvents = []string{
+	`{"method":"Network.requestWillBeSent","params":{"requestId":"1","loaderId":"1","documentURL":"http://example.com/","request":{"url":"http://example.com/?q=selenoid","method":"GET","headers":{"Accept":"text/html","Cookie":"token=secret"}},"timestamp":100,"wallTime":1700000000,"type":"Document"}}`,
+	`{"method":"Network.responseReceived","params":{"requestId":"1","loaderId":"1","timestamp":100.25,"type":"Document","response":{"url":"http://example.com/?q=selenoid","status":200,"statusText":"OK","headers":{"Content-Type":"text/html"},"mimeType":"text/html","protocol":"http/1.1","remoteIPAddress":"10.0.0.1"}}}`,
+	`{"method":"Network.loadingFinished","params":{"requestId":"1","timestamp":100.5,"encodedDataLength":1234}}`,
+	`{"method":"Network.requestWillBeSent","params":{"requestId":"2","loaderId":"1","documentURL":"http://example.com/","request":{"url":"http://missing.example.com/script.js","method":"GET","headers":{}},"timestamp":100.6,"wallTime":1700000000.6,"type":"Script"}}`,
+	`{"method":"Network.loadingFailed","params":{"requestId":"2","timestamp":100.7,"type":"Script","errorText":"net::ERR_NAME_NOT_RESOLVED"}}`,
+}
+
+func TestHAR(t *testing.T) {
+	selenium := Selenium()
+	mux := http.NewServeMux()
+	mux.Handle("/session", selenium)
+	mux.Handle("/session/", selenium)
+	events := make(chan string, len(harEvents))
+	for _, e := range harEvents {
+		events <- e
+	}
+	mux.Handle("/", devtoolsStub("Network.enable", events))
+	manager = &HTTPTest{Handler: mux}
+
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"desiredCapabilities":{"enableHAR":true}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var sess map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sess))
+	sid := sess["sessionId"]
+
+	hr, ok := getHARRecorder(sid)
+	assert.True(t, ok)
+	waitFor(t, func() bool {
+		hr.lock.Lock()
+		defer hr.lock.Unlock()
+		return len(hr.entries) == 2 && len(hr.pending) == 0
+	})
+
+	req, _ := http.NewRequest(http.MethodDelete, With(srv.URL).Path("/wd/hub/session/"+sid), nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+
+	name := filepath.Join(logOutputDir, preprocessSessionId(sid)+harFileExtension)
+	waitFor(t, func() bool {
+		_, err := os.Stat(name)
+		return err == nil
+	})
+	defer os.Remove(name)
+	assert.Equal(t, fileTypeOf(name), harFileType)
+	data, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	var har harFile
+	assert.NoError(t, json.Unmarshal(data, &har))
+	assert.Equal(t, har.Log.Version, harVersion)
+	assert.Equal(t, har.Log.Creator.Name, "Selenoid")
+	assert.Len(t, har.Log.Entries, 2)
+
+	page := har.Log.Entries[0]
+	assert.Equal(t, page.Request.Method, http.MethodGet)
+	assert.Equal(t, page.Request.URL, "http://example.com/?q=selenoid")
+	assert.Equal(t, page.Request.HTTPVersion, "http/1.1")
+	assert.Equal(t, page.Request.Headers, []harHeader{{"Accept", "text/html"}, {"Cookie", redacted}})
+	assert.Equal(t, page.Request.QueryString, []harHeader{{"q", "selenoid"}})
+	assert.Equal(t, page.Response.Status, http.StatusOK)
+	assert.Equal(t, page.Response.Content.MimeType, "text/html")
+	assert.Equal(t, page.Response.Content.Size, 1234)
+	assert.Equal(t, page.ServerIPAddress, "10.0.0.1")
+	assert.Equal(t, page.StartedDateTime.Unix(), int64(1700000000))
+	assert.InDelta(t, page.Timings.Wait, 250, 0.001)
+	assert.InDelta(t, page.Timings.Receive, 250, 0.001)
+	assert.InDelta(t, page.Time, 500, 0.001)
+
+	failed := har.Log.Entries[1]
+	assert.Equal(t, failed.Request.URL, "http://missing.example.com/script.js")
+	assert.Equal(t, failed.Response.Status, 0)
+	assert.Equal(t, failed.Error, "net::ERR_NAME_NOT_RESOLVED")
+	assert.Equal(t, failed.ResourceType, "Script")
+}
+
+func TestNoHARByDefault(t *testing.T) {
+	manager = &HTTPTest{Handler: Selenium()}
+
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"desiredCapabilities":{}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var sess map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sess))
+	sid := sess["sessionId"]
+	_, ok := getHARRecorder(sid)
+	assert.False(t, ok)
+
+	req, _ := http.NewRequest(http.MethodDelete, With(srv.URL).Path("/wd/hub/session/"+sid), nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	_, err = os.Stat(filepath.Join(logOutputDir, preprocessSessionId(sid)+harFileExtension))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestHARMaxEntries(t *testing.T) {
+	defer func(n int) { harMaxEntries = n }(harMaxEntries)
+	harMaxEntries = 1
+	hr := &harRecorder{pending: make(map[network.RequestID]*harEntry)}
+	for _, id := range []network.RequestID{"1", "2", "3"} {
+		hr.onRequest(&network.RequestWillBeSentReply{RequestID: id, Request: network.Request{Method: http.MethodGet, URL: "http://example.com/" + string(id)}})
+	}
+	hr.finish("2", 0, 0, "")
+	assert.Len(t, hr.pending, 1)
+
+	f, err := os.CreateTemp("", "selenoid*.har")
+	assert.NoError(t, err)
+	_ = f.Close()
+	defer os.Remove(f.Name())
+	dropped, err := hr.write(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, dropped, 2)
+	data, err := os.ReadFile(f.Name())
+	assert.NoError(t, err)
+	var har harFile
+	assert.NoError(t, json.Unmarshal(data, &har))
+	assert.Len(t, har.Log.Entries, 1)
+	assert.Equal(t, har.Log.Entries[0].Request.URL, "http://example.com/1")
+	assert.Equal(t, har.Log.Comment, "2 requests were dropped after reaching the limit of 1 entries")
+}
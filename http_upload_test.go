@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aerokube/selenoid/upload"
+	assert "github.com/stretchr/testify/require"
+)
+
+type davServer struct {
+	lock        sync.Mutex
+	collections map[string]bool
+	files       map[string]string
+	headers     http.Header
+}
+
+func (d *davServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	switch r.Method {
+	case "MKCOL":
+		if d.collections[r.URL.Path] {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		d.collections[r.URL.Path] = true
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPut:
+		if r.Header.Get("Authorization") != "Bearer some-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		d.files[r.URL.Path] = string(data)
+		d.headers = r.Header.Clone()
+		d.headers.Set("Transfer-Encoding", r.TransferEncoding[0])
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func TestHTTPUploader(t *testing.T) {
+	dav := &davServer{collections: make(map[string]bool), files: make(map[string]string)}
+	srv := httptest.NewServer(dav)
+	defer srv.Close()
+
+	headers := make(upload.Headers)
+	assert.NoError(t, headers.Set("X-Storage-Class: cold"))
+	assert.Error(t, headers.Set("broken"))
+	uploader := &upload.HTTPUploader{
+		URL:        srv.URL + "/dav",
+		KeyPattern: "$quota/$sessionId/$fileType$fileExtension",
+		Token:      "some-token",
+		Headers:    headers,
+		CreateDirs: true,
+	}
+	uploader.Init()
+	assert.True(t, uploader.Configured())
+
+	f, _ := os.CreateTemp("", "some-file*.mp4")
+	_, _ = f.WriteString("test-data")
+	_ = f.Close()
+	defer os.Remove(f.Name())
+
+	location, uploaded, err := uploader.UploadTo(testCreatedFile(f.Name()))
+	assert.NoError(t, err)
+	assert.True(t, uploaded)
+	_, err = os.Stat(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, location, srv.URL+"/dav/some-user/some-session-id/video.mp4")
+	link, ok, err := uploader.Link(location, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, link, location)
+	_, ok, _ = uploader.Link("https://example.com/dav/video.mp4", time.Hour)
+	assert.False(t, ok)
+
+	dav.lock.Lock()
+	defer dav.lock.Unlock()
+	assert.Equal(t, dav.files, map[string]string{"/dav/some-user/some-session-id/video.mp4": "test-data"})
+	assert.True(t, dav.collections["/dav/some-user/"])
+	assert.True(t, dav.collections["/dav/some-user/some-session-id/"])
+	assert.Equal(t, dav.headers.Get("Content-Type"), "video/mp4")
+	assert.Equal(t, dav.headers.Get("X-Storage-Class"), "cold")
+	assert.Equal(t, dav.headers.Get("Transfer-Encoding"), "chunked")
+}
+
+func TestHTTPUploaderFailure(t *testing.T) {
+	srv := httptest.NewServer(&davServer{collections: make(map[string]bool), files: make(map[string]string)})
+	defer srv.Close()
+
+	uploader := &upload.HTTPUploader{URL: srv.URL, KeyPattern: "$fileName", KeepFiles: true}
+	uploader.Init()
+
+	f, _ := os.CreateTemp("", "some-file*.log")
+	_ = f.Close()
+	defer os.Remove(f.Name())
+
+	uploaded, err := uploader.Upload(testCreatedFile(f.Name()))
+	assert.Error(t, err)
+	assert.False(t, uploaded)
+	_, err = os.Stat(f.Name())
+	assert.NoError(t, err)
+}
@@ -10,9 +10,9 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
-	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -20,8 +20,11 @@ import (
 	"time"
 
 	ggr "github.com/aerokube/ggr/config"
+	"github.com/aerokube/selenoid/admission"
 	"github.com/aerokube/selenoid/config"
+	"github.com/aerokube/selenoid/event"
 	"github.com/aerokube/selenoid/jsonerror"
+	"github.com/aerokube/selenoid/policy"
 	"github.com/aerokube/selenoid/protect"
 	"github.com/aerokube/selenoid/service"
 	"github.com/aerokube/selenoid/session"
@@ -49,14 +52,34 @@ var (
 	sessions                 = session.NewMap()
 	confPath                 string
 	logConfPath              string
+	policyConfPath           string
+	admissionURL             string
+	admissionTimeout         time.Duration
+	admissionFailOpen        bool
+	admissionHook            *admission.Hook
+	adminToken               string
+	drainExit                bool
 	captureDriverLogs        bool
 	disablePrivileged        bool
 	videoOutputDir           string
 	videoRecorderImage       string
 	logOutputDir             string
+	screenshotOutputDir      string
 	saveAllLogs              bool
 	ggrHost                  *ggr.Host
 	conf                     *config.Config
+	retention                Retention
+	minFreeSpace             byteSize
+	lowDiskSpaceAction       string
+	previewFPS               float64
+	commandLogBodySize       int
+	harMaxEntries            int
+	disableVideoSubtitles    bool
+	devtoolsAllowedMethods   string
+	vncPassword              string
+	devtoolsAllowlist        []string
+	retentionInterval        time.Duration
+	policies                 = policy.New()
 	queue                    *protect.Queue
 	manager                  service.Manager
 	cli                      *client.Client
@@ -71,12 +94,19 @@ var (
 func init() {
 	var mem service.MemLimit
 	var cpu service.CpuLimit
+	var retentionMaxSize byteSize
 	flag.BoolVar(&disableDocker, "disable-docker", false, "Disable docker support")
 	flag.BoolVar(&disableQueue, "disable-queue", false, "Disable wait queue")
 	flag.BoolVar(&enableFileUpload, "enable-file-upload", false, "File upload support")
 	flag.StringVar(&listen, "listen", ":4444", "Network address to accept connections")
 	flag.StringVar(&confPath, "conf", "config/browsers.json", "Browsers configuration file")
 	flag.StringVar(&logConfPath, "log-conf", "", "Container logging configuration file")
+	flag.StringVar(&policyConfPath, "policy-conf", "", "Capabilities policy configuration file")
+	flag.StringVar(&admissionURL, "admission-url", "", "Admission hook URL called before starting new sessions")
+	flag.DurationVar(&admissionTimeout, "admission-timeout", 5*time.Second, "Admission hook request timeout in time.Duration format")
+	flag.StringVar(&adminToken, "admin-token", "", "Bearer token enabling admin API")
+	flag.BoolVar(&drainExit, "drain-exit", false, "Whether to exit when drain mode is enabled and all sessions are finished")
+	flag.BoolVar(&admissionFailOpen, "admission-fail-open", false, "Whether to create sessions when admission hook is not available")
 	flag.IntVar(&limit, "limit", 5, "Simultaneous container runs")
 	flag.IntVar(&retryCount, "retry-count", 1, "New session attempts retry count")
 	flag.DurationVar(&timeout, "timeout", 60*time.Second, "Session idle timeout in time.Duration format")
@@ -91,11 +121,36 @@ func init() {
 	flag.BoolVar(&captureDriverLogs, "capture-driver-logs", false, "Whether to add driver process logs to Selenoid output")
 	flag.BoolVar(&disablePrivileged, "disable-privileged", false, "Whether to disable privileged container mode")
 	flag.StringVar(&videoOutputDir, "video-output-dir", "video", "Directory to save recorded video to")
+	flag.BoolVar(&disableVideoSubtitles, "disable-video-subtitles", false, "Whether to not save WebVTT subtitles with WebDriver commands next to recorded video")
 	flag.StringVar(&videoRecorderImage, "video-recorder-image", "selenoid/video-recorder:latest-release", "Image to use as video recorder")
 	flag.StringVar(&logOutputDir, "log-output-dir", "", "Directory to save session log to")
+	flag.StringVar(&screenshotOutputDir, "screenshot-output-dir", "", "Directory to save final screenshots and page sources to")
+	flag.IntVar(&commandLogBodySize, "command-log-body-size", 1024, "Maximum size of request and response bodies saved to command log, 0 to not save bodies")
+	flag.IntVar(&harMaxEntries, "har-max-entries", 10000, "Maximum number of requests saved to HAR file of one session, 0 for no limit")
 	flag.BoolVar(&saveAllLogs, "save-all-logs", false, "Whether to save all logs without considering capabilities")
+	flag.DurationVar(&(retention.MaxAge), "retention-max-age", 0, "Maximum age of video and log files in time.Duration format, e.g. 168h")
+	flag.Var(&retentionMaxSize, "retention-max-size", "Maximum total size of files in video and log directories, e.g. 500m or 10g")
+	flag.IntVar(&(retention.MaxFiles), "retention-max-files", 0, "Maximum number of files in video and log directories")
+	flag.DurationVar(&retentionInterval, "retention-interval", time.Minute, "Interval between retention checks in time.Duration format")
+	flag.Var(&minFreeSpace, "min-free-space", "Minimum free disk space in video and log directories required to record sessions, e.g. 1g")
+	flag.StringVar(&lowDiskSpaceAction, "low-disk-space-action", lowDiskSpaceReject, "What to do with sessions requesting recording when disk space is low: reject or disable recording")
+	flag.StringVar(&vncPassword, "vnc-password", "selenoid", "VNC server password used to record sessions requesting enableVNCRecording")
+	flag.StringVar(&devtoolsAllowedMethods, "devtools-allowed-methods", "", "Comma-separated DevTools protocol methods allowed for clients, e.g. Page.*,Runtime.evaluate, all methods are allowed by default")
+	flag.Float64Var(&previewFPS, "preview-fps", 1, "Frames per second in session preview stream")
 	flag.DurationVar(&gracefulPeriod, "graceful-period", 300*time.Second, "graceful shutdown period in time.Duration format, e.g. 300s or 500ms")
 	flag.Parse()
+	retention.MaxSize = int64(retentionMaxSize)
+	if lowDiskSpaceAction != lowDiskSpaceReject && lowDiskSpaceAction != lowDiskSpaceDisable {
+		log.Fatalf("[-] [INIT] [Invalid low disk space action: %s]", lowDiskSpaceAction)
+	}
+	if previewFPS <= 0 {
+		log.Fatalf("[-] [INIT] [Invalid preview frame rate: %v]", previewFPS)
+	}
+	for _, method := range strings.Split(devtoolsAllowedMethods, ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			devtoolsAllowlist = append(devtoolsAllowlist, method)
+		}
+	}
 
 	if version {
 		showVersion()
@@ -116,11 +171,26 @@ func init() {
 	if err != nil {
 		log.Fatalf("[-] [INIT] [%s: %v]", os.Args[0], err)
 	}
+	err = policies.Load(policyConfPath)
+	if err != nil {
+		log.Fatalf("[-] [INIT] [%s: %v]", os.Args[0], err)
+	}
+	admissionHook = admission.New(admissionURL, admissionTimeout, admissionFailOpen)
+	if admissionHook.Enabled() {
+		log.Printf("[-] [INIT] [Using admission hook: %s]", admissionURL)
+	}
 	onSIGHUP(func() {
 		err := conf.Load(confPath, logConfPath)
 		if err != nil {
 			log.Printf("[-] [INIT] [%s: %v]", os.Args[0], err)
 		}
+		err = policies.Load(policyConfPath)
+		if err != nil {
+			log.Printf("[-] [INIT] [%s: %v]", os.Args[0], err)
+		}
+	})
+	onSIGUSR1(func() {
+		setDraining(!queue.Draining())
 	})
 	inDocker := false
 	_, err = os.Stat("/.dockerenv")
@@ -153,6 +223,17 @@ func init() {
 			log.Printf("[-] [INIT] [Saving all logs]")
 		}
 	}
+	if screenshotOutputDir != "" {
+		screenshotOutputDir, err = filepath.Abs(screenshotOutputDir)
+		if err != nil {
+			log.Fatalf("[-] [INIT] [Invalid screenshot output dir %s: %v]", screenshotOutputDir, err)
+		}
+		err = os.MkdirAll(screenshotOutputDir, os.FileMode(0644))
+		if err != nil {
+			log.Fatalf("[-] [INIT] [Failed to create screenshot output dir %s: %v]", screenshotOutputDir, err)
+		}
+		log.Printf("[-] [INIT] [Screenshots Dir: %s]", screenshotOutputDir)
+	}
 
 	upload.Init()
 
@@ -272,8 +353,34 @@ func parseGgrHost(s string) *ggr.Host {
 	return host
 }
 
+func setDraining(draining bool) {
+	queue.Drain(draining)
+	if draining {
+		log.Printf("[-] [DRAIN_MODE_ENABLED] [%d]", sessions.Len())
+		return
+	}
+	log.Printf("[-] [DRAIN_MODE_DISABLED]")
+}
+
+func drained() chan struct{} {
+	ch := make(chan struct{})
+	if !drainExit {
+		return ch
+	}
+	go func() {
+		for range time.Tick(time.Second) {
+			if queue.Draining() && sessions.Len() == 0 && queue.Pending() == 0 && queue.Queued() == 0 {
+				log.Printf("[-] [DRAINED]")
+				close(ch)
+				return
+			}
+		}
+	}()
+	return ch
+}
+
 func onSIGHUP(fn func()) {
-	sig := make(chan os.Signal)
+	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGHUP)
 	go func() {
 		for {
@@ -312,11 +419,12 @@ func post(next http.HandlerFunc) http.HandlerFunc {
 func ping(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(struct {
-		Uptime         string `json:"uptime"`
-		LastReloadTime string `json:"lastReloadTime"`
-		NumRequests    uint64 `json:"numRequests"`
-		Version        string `json:"version"`
-	}{time.Since(startTime).String(), conf.LastReloadTime.Format(time.RFC3339), getSerial(), gitRevision})
+		Uptime         string     `json:"uptime"`
+		LastReloadTime string     `json:"lastReloadTime"`
+		NumRequests    uint64     `json:"numRequests"`
+		Version        string     `json:"version"`
+		DiskSpace      []dirSpace `json:"diskSpace,omitempty"`
+	}{time.Since(startTime).String(), conf.LastReloadTime.Format(time.RFC3339), getSerial(), gitRevision, diskSpace()})
 }
 
 func video(w http.ResponseWriter, r *http.Request) {
@@ -327,7 +435,10 @@ func video(w http.ResponseWriter, r *http.Request) {
 	}
 	user, remote := info.RequestInfo(r)
 	if _, ok := r.URL.Query()[jsonParam]; ok {
-		listFilesAsJson(requestId, w, videoOutputDir, "VIDEO_ERROR")
+		listFilesAsJson(requestId, w, r, videoOutputDir, "VIDEO_ERROR", videoFileType)
+		return
+	}
+	if serveUploadedFile(requestId, w, r, videoOutputDir, paths.Video, "UPLOADED_VIDEO_FILE", videoFileType) {
 		return
 	}
 	log.Printf("[%d] [VIDEO_LISTING] [%s] [%s]", requestId, user, remote)
@@ -341,6 +452,11 @@ func deleteFileIfExists(requestId uint64, w http.ResponseWriter, r *http.Request
 	filePath := filepath.Join(dir, fileName)
 	_, err := os.Stat(filePath)
 	if err != nil {
+		if upload.Forget(fileName) {
+			artifacts.forget(fileName)
+			log.Printf("[%d] [%s] [%s] [%s] [%s] [Forgot uploaded file]", requestId, status, user, remote, fileName)
+			return
+		}
 		http.Error(w, fmt.Sprintf("Unknown file %s", filePath), http.StatusNotFound)
 		return
 	}
@@ -349,16 +465,53 @@ func deleteFileIfExists(requestId uint64, w http.ResponseWriter, r *http.Request
 		http.Error(w, fmt.Sprintf("Failed to delete file %s: %v", filePath, err), http.StatusInternalServerError)
 		return
 	}
+	upload.Forget(fileName)
+	artifacts.forget(fileName)
 	log.Printf("[%d] [%s] [%s] [%s] [%s]", requestId, status, user, remote, fileName)
 }
 
+// serveUploadedFile - redirects to uploaded copy of a file missing in local directory
+func serveUploadedFile(requestId uint64, w http.ResponseWriter, r *http.Request, dir string, prefix string, status string, fileType string) bool {
+	fileName := strings.TrimPrefix(r.URL.Path, prefix)
+	if fileName == "" || strings.Contains(fileName, "/") {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(dir, fileName)); !os.IsNotExist(err) {
+		return false
+	}
+	link, ok, err := upload.Link(fileName, fileType)
+	if err != nil {
+		log.Printf("[%d] [%s] [%s] [%v]", requestId, status, fileName, err)
+	}
+	if !ok {
+		return false
+	}
+	user, remote := info.RequestInfo(r)
+	log.Printf("[%d] [%s] [%s] [%s] [%s]", requestId, status, user, remote, fileName)
+	serveLink(w, r, link)
+	return true
+}
+
+// serveLink - serves files from local archive directly and redirects to other links
+func serveLink(w http.ResponseWriter, r *http.Request, link string) {
+	if u, err := url.Parse(link); err == nil && u.Scheme == "file" {
+		http.ServeFile(w, r, filepath.FromSlash(u.Path))
+		return
+	}
+	http.Redirect(w, r, link, http.StatusFound)
+}
+
 var paths = struct {
-	Video, VNC, Logs, Devtools, Download, Clipboard, File, Ping, Status, Error, WdHub, Welcome string
+	Video, VNC, Preview, Console, Logs, Artifacts, Devtools, BiDi, Download, Clipboard, File, Ping, Status, Error, WdHub, Admin, Welcome string
 }{
 	Video:     "/video/",
 	VNC:       "/vnc/",
+	Preview:   "/preview/",
+	Console:   "/console/",
 	Logs:      "/logs/",
+	Artifacts: "/artifacts/",
 	Devtools:  "/devtools/",
+	BiDi:      "/bidi/",
 	Download:  "/download/",
 	Clipboard: "/clipboard/",
 	Status:    "/status",
@@ -366,6 +519,7 @@ var paths = struct {
 	Ping:      "/ping",
 	Error:     "/error",
 	WdHub:     "/wd/hub",
+	Admin:     "/admin/",
 	Welcome:   "/",
 }
 
@@ -387,14 +541,21 @@ func handler() http.Handler {
 	})
 	root.HandleFunc(paths.Ping, ping)
 	root.Handle(paths.VNC, websocket.Handler(vnc))
+	root.HandleFunc(paths.Preview, preview)
+	root.Handle(paths.Console, websocket.Handler(console))
 	root.HandleFunc(paths.Logs, logs)
 	root.HandleFunc(paths.Video, video)
-	root.HandleFunc(paths.Download, reverseProxy(func(sess *session.Session) string { return sess.HostPort.Fileserver }, "DOWNLOADING_FILE"))
-	root.HandleFunc(paths.Clipboard, reverseProxy(func(sess *session.Session) string { return sess.HostPort.Clipboard }, "CLIPBOARD"))
-	root.HandleFunc(paths.Devtools, reverseProxy(func(sess *session.Session) string { return sess.HostPort.Devtools }, "DEVTOOLS"))
+	root.HandleFunc(paths.Artifacts, sessionArtifacts)
+	root.HandleFunc(paths.Download, reverseProxy(func(sess *session.Session) string { return sess.HostPort.Fileserver }, "DOWNLOADING_FILE", nil))
+	root.HandleFunc(paths.Clipboard, reverseProxy(func(sess *session.Session) string { return sess.HostPort.Clipboard }, "CLIPBOARD", nil))
+	root.HandleFunc(paths.Devtools, devtools)
+	root.HandleFunc(paths.BiDi, bidi)
 	if enableFileUpload {
 		root.HandleFunc(paths.File, fileUpload)
 	}
+	if adminToken != "" {
+		root.Handle(paths.Admin, admin())
+	}
 	root.HandleFunc(paths.Welcome, welcome)
 	return root
 }
@@ -408,13 +569,14 @@ func main() {
 	log.Printf("[-] [INIT] [Timezone: %s]", time.Local)
 	log.Printf("[-] [INIT] [Listening on %s]", listen)
 
-	stop := make(chan os.Signal)
+	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
 	server := &http.Server{
 		Addr:    listen,
 		Handler: handler(),
 	}
+	startJanitor(retentionInterval)
 	e := make(chan error)
 	go func() {
 		e <- server.ListenAndServe()
@@ -423,22 +585,41 @@ func main() {
 	case err := <-e:
 		log.Fatalf("[-] [INIT] [Failed to start: %v]", err)
 	case <-stop:
+	case <-drained():
 	}
 
 	log.Printf("[-] [SHUTTING_DOWN] [%s]", gracefulPeriod)
+	queue.Drain(true)
 	ctx, cancel := context.WithTimeout(context.Background(), gracefulPeriod)
 	defer cancel()
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("[-] [SHUTTING_DOWN] [Failed to shut down: %v]", err)
-	}
+	waitForSessions(ctx)
 
 	sessions.Each(func(k string, s *session.Session) {
-		if enableFileUpload {
-			_ = os.RemoveAll(path.Join(os.TempDir(), k))
-		}
-		s.Cancel()
+		// Counted before starting, so that waiting for files does not finish before session is stopped
+		stopping.Add(1)
+		go func() {
+			defer stopping.Done()
+			saveFinalState(serial(), k, s)
+			s.Lock.Lock()
+			if _, ok := sessions.Get(k); !ok {
+				s.Lock.Unlock()
+				return
+			}
+			log.Printf("[-] [SHUTTING_DOWN] [Stopping session %s]", k)
+			stopSession := removeSession(serial(), k, s)
+			s.Lock.Unlock()
+			stopSession()
+		}()
 	})
 
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), sessionDeleteTimeout)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[-] [SHUTTING_DOWN] [Failed to shut down: %v]", err)
+	}
+
+	waitForFiles()
+
 	if !disableDocker {
 		err := cli.Close()
 		if err != nil {
@@ -446,3 +627,42 @@ func main() {
 		}
 	}
 }
+
+func waitForSessions(ctx context.Context) {
+	const progressInterval = 10 * time.Second
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastProgress := time.Now()
+	for n := sessions.Len(); n > 0; n = sessions.Len() {
+		if time.Since(lastProgress) >= progressInterval {
+			log.Printf("[-] [SHUTTING_DOWN] [Waiting for %d sessions]", n)
+			lastProgress = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("[-] [SHUTTING_DOWN] [Graceful period expired with %d sessions]", n)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func waitForFiles() {
+	done := make(chan struct{})
+	go func() {
+		stopping.Wait()
+		event.Wait()
+		upload.Stop()
+		close(done)
+	}()
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			log.Printf("[-] [SHUTTING_DOWN] [Waiting for %d pending files and uploads]", event.Running())
+		}
+	}
+}
@@ -14,8 +14,6 @@ import (
 	"github.com/aerokube/selenoid/session"
 )
 
-const metadataFileExtension = ".json"
-
 func init() {
 	mp := &MetadataProcessor{}
 	event.AddSessionStoppedListener(mp)
@@ -48,7 +46,7 @@ func (mp *MetadataProcessor) OnSessionStopped(stoppedSession event.StoppedSessio
 		createdFile := event.CreatedFile{
 			Event: stoppedSession.Event,
 			Name:  filename,
-			Type:  "metadata",
+			Type:  metadataFileType,
 		}
 		event.FileCreated(createdFile)
 	}
@@ -0,0 +1,214 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aerokube/selenoid/session"
+)
+
+// labelsCapability - labels set by rules are added to requested ones instead of replacing them
+const labelsCapability = "labels"
+
+// capabilities - names of capabilities that can be used in rules
+var capabilities = func() map[string]struct{} {
+	ret := make(map[string]struct{})
+	t := reflect.TypeOf(session.Caps{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" && t.Field(i).Type != reflect.TypeOf(&session.Caps{}) {
+			ret[name] = struct{}{}
+		}
+	}
+	return ret
+}()
+
+// Rule - capabilities policy applied to matching quota and browser
+type Rule struct {
+	Quota             string          `json:"quota,omitempty"`
+	Browser           string          `json:"browser,omitempty"`
+	Allow             []string        `json:"allow,omitempty"`
+	Deny              []string        `json:"deny,omitempty"`
+	Set               json.RawMessage `json:"set,omitempty"`
+	MaxSessionTimeout string          `json:"maxSessionTimeout,omitempty"`
+
+	maxSessionTimeout time.Duration
+	// set - capabilities overriding requested ones without labels
+	set json.RawMessage
+	// labels - labels added to requested ones
+	labels map[string]string
+}
+
+// Policies - ordered list of capability rules
+type Policies struct {
+	lock  sync.RWMutex
+	rules []*Rule
+}
+
+// New creates empty policies allowing everything
+func New() *Policies {
+	return &Policies{}
+}
+
+// Load loads policies from file
+func (p *Policies) Load(filename string) error {
+	if filename == "" {
+		return nil
+	}
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("policy config: read error: %v", err)
+	}
+	var rules []*Rule
+	if err := json.Unmarshal(buf, &rules); err != nil {
+		return fmt.Errorf("policy config: parse error: %v", err)
+	}
+	for i, r := range rules {
+		if err := r.init(); err != nil {
+			return fmt.Errorf("policy config: rule %d: %v", i, err)
+		}
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.rules = rules
+	log.Printf("[-] [INIT] [Loaded %d policy rules from %s]", len(rules), filename)
+	return nil
+}
+
+func (r *Rule) init() error {
+	if r.Quota != "" {
+		if _, err := path.Match(r.Quota, ""); err != nil {
+			return fmt.Errorf("invalid quota pattern %s: %v", r.Quota, err)
+		}
+	}
+	if r.Browser != "" {
+		if _, err := path.Match(r.Browser, ""); err != nil {
+			return fmt.Errorf("invalid browser pattern %s: %v", r.Browser, err)
+		}
+	}
+	for _, c := range append(append([]string{}, r.Deny...), r.Allow...) {
+		if _, ok := capabilities[c]; !ok {
+			return fmt.Errorf("unknown capability %s", c)
+		}
+	}
+	if len(r.Set) > 0 {
+		var set map[string]json.RawMessage
+		if err := json.Unmarshal(r.Set, &set); err != nil {
+			return fmt.Errorf("invalid set: %v", err)
+		}
+		for c := range set {
+			if _, ok := capabilities[c]; !ok {
+				return fmt.Errorf("unknown capability %s in set", c)
+			}
+		}
+		if labels, ok := set[labelsCapability]; ok {
+			if err := json.Unmarshal(labels, &r.labels); err != nil {
+				return fmt.Errorf("invalid labels in set: %v", err)
+			}
+			delete(set, labelsCapability)
+		}
+		r.set, _ = json.Marshal(set)
+		// Values are checked when loading, not when session is requested
+		if err := (&session.Caps{}).Patch(r.set); err != nil {
+			return fmt.Errorf("invalid set: %v", err)
+		}
+	}
+	if r.MaxSessionTimeout != "" {
+		t, err := time.ParseDuration(r.MaxSessionTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid maxSessionTimeout: %v", err)
+		}
+		r.maxSessionTimeout = t
+	}
+	return nil
+}
+
+func (r *Rule) matches(quota string, browserName string) bool {
+	return matches(r.Quota, quota) && matches(r.Browser, browserName)
+}
+
+func matches(pattern string, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+// Apply - evaluate all rules matching quota and browser against capabilities. Capabilities are rewritten in place.
+// Returns maximum allowed session timeout (zero when not limited) or an error when requested capabilities are denied.
+func (p *Policies) Apply(quota string, caps *session.Caps) (time.Duration, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	var maxTimeout time.Duration
+	denied := make(map[string]struct{})
+	browserName := caps.BrowserName()
+	for _, r := range p.rules {
+		if !r.matches(quota, browserName) {
+			continue
+		}
+		for _, c := range r.Deny {
+			denied[c] = struct{}{}
+		}
+		for _, c := range r.Allow {
+			delete(denied, c)
+		}
+		if len(r.set) > 0 {
+			if err := caps.Patch(r.set); err != nil {
+				return 0, fmt.Errorf("failed to apply policy: %v", err)
+			}
+		}
+		if len(r.labels) > 0 {
+			// Rule labels are shared by all sessions, so they are copied to a new map
+			labels := make(map[string]string, len(caps.Labels)+len(r.labels))
+			for k, v := range caps.Labels {
+				labels[k] = v
+			}
+			for k, v := range r.labels {
+				labels[k] = v
+			}
+			caps.Labels = labels
+		}
+		if r.maxSessionTimeout > 0 && (maxTimeout == 0 || r.maxSessionTimeout < maxTimeout) {
+			maxTimeout = r.maxSessionTimeout
+		}
+	}
+	if len(denied) == 0 {
+		return maxTimeout, nil
+	}
+	present, err := capabilityNames(caps)
+	if err != nil {
+		return 0, fmt.Errorf("failed to apply policy: %v", err)
+	}
+	var forbidden []string
+	for c := range denied {
+		if _, ok := present[c]; ok {
+			forbidden = append(forbidden, c)
+		}
+	}
+	if len(forbidden) > 0 {
+		sort.Strings(forbidden)
+		return 0, fmt.Errorf("capabilities not allowed for quota %s: %s", quota, strings.Join(forbidden, ", "))
+	}
+	return maxTimeout, nil
+}
+
+func capabilityNames(caps *session.Caps) (map[string]interface{}, error) {
+	c := *caps
+	c.ExtensionCapabilities = nil
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]interface{})
+	err = json.Unmarshal(data, &ret)
+	return ret, err
+}
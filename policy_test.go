@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aerokube/selenoid/policy"
+	"github.com/aerokube/selenoid/session"
+	assert "github.com/stretchr/testify/require"
+)
+
+const testPolicies = `[
+	{
+		"deny": ["env", "hostsEntries", "applicationContainers", "additionalNetworks"]
+	},
+	{
+		"quota": "infra-*",
+		"allow": ["env"]
+	},
+	{
+		"quota": "compliance",
+		"browser": "chrome",
+		"set": {"enableVideo": true},
+		"maxSessionTimeout": "5m"
+	}
+]`
+
+func loadTestPolicies(t *testing.T, data string) *policy.Policies {
+	confFile := configfile(data)
+	defer os.Remove(confFile)
+	p := policy.New()
+	assert.NoError(t, p.Load(confFile))
+	return p
+}
+
+func TestPolicyEmpty(t *testing.T) {
+	p := policy.New()
+	assert.NoError(t, p.Load(""))
+	caps := session.Caps{Name: "firefox", Env: []string{"A=B"}}
+	maxTimeout, err := p.Apply("any-user", &caps)
+	assert.NoError(t, err)
+	assert.Equal(t, maxTimeout, time.Duration(0))
+}
+
+func TestPolicyParseError(t *testing.T) {
+	confFile := configfile(`[{"maxSessionTimeout": "wrong-value"}]`)
+	defer os.Remove(confFile)
+	p := policy.New()
+	assert.Error(t, p.Load(confFile))
+}
+
+func TestPolicyDeny(t *testing.T) {
+	p := loadTestPolicies(t, testPolicies)
+	caps := session.Caps{Name: "firefox", Env: []string{"A=B"}, HostsEntries: []string{"example.com:127.0.0.1"}}
+	_, err := p.Apply("some-user", &caps)
+	assert.Error(t, err)
+	assert.Equal(t, err.Error(), "capabilities not allowed for quota some-user: env, hostsEntries")
+
+	caps = session.Caps{Name: "firefox", Labels: map[string]string{"key": "value"}}
+	_, err = p.Apply("some-user", &caps)
+	assert.NoError(t, err)
+}
+
+func TestPolicyAllow(t *testing.T) {
+	p := loadTestPolicies(t, testPolicies)
+	caps := session.Caps{Name: "firefox", Env: []string{"A=B"}}
+	_, err := p.Apply("infra-team", &caps)
+	assert.NoError(t, err)
+
+	caps.HostsEntries = []string{"example.com:127.0.0.1"}
+	_, err = p.Apply("infra-team", &caps)
+	assert.Error(t, err)
+}
+
+func TestPolicyRewrite(t *testing.T) {
+	p := loadTestPolicies(t, testPolicies)
+	caps := session.Caps{Name: "chrome"}
+	maxTimeout, err := p.Apply("compliance", &caps)
+	assert.NoError(t, err)
+	assert.True(t, caps.Video)
+	assert.Equal(t, maxTimeout, 5*time.Minute)
+
+	caps = session.Caps{Name: "firefox"}
+	maxTimeout, err = p.Apply("compliance", &caps)
+	assert.NoError(t, err)
+	assert.False(t, caps.Video)
+	assert.Equal(t, maxTimeout, time.Duration(0))
+}
+
+func TestPolicySetZeroValues(t *testing.T) {
+	p := loadTestPolicies(t, `[{"quota": "cheap", "set": {"enableVideo": false, "enableVNC": false, "screenResolution": "", "labels": {"tier": "cheap"}}}]`)
+	caps := session.Caps{Name: "chrome", Video: true, VNC: true, ScreenResolution: "1920x1080x24", Labels: map[string]string{"team": "qa"}}
+	_, err := p.Apply("cheap", &caps)
+	assert.NoError(t, err)
+	assert.False(t, caps.Video)
+	assert.False(t, caps.VNC)
+	assert.Empty(t, caps.ScreenResolution)
+	assert.Equal(t, caps.Name, "chrome")
+	assert.Equal(t, caps.Labels, map[string]string{"team": "qa", "tier": "cheap"})
+
+	// Labels of one session do not leak to another one
+	other := session.Caps{Name: "firefox"}
+	_, err = p.Apply("cheap", &other)
+	assert.NoError(t, err)
+	assert.Equal(t, other.Labels, map[string]string{"tier": "cheap"})
+	other.Labels["extra"] = "value"
+	caps = session.Caps{Name: "firefox"}
+	_, err = p.Apply("cheap", &caps)
+	assert.NoError(t, err)
+	assert.Equal(t, caps.Labels, map[string]string{"tier": "cheap"})
+}
+
+func TestPolicyUnknownCapability(t *testing.T) {
+	for _, data := range []string{
+		`[{"deny": ["enviroment"]}]`,
+		`[{"allow": ["selenoid:options"]}]`,
+		`[{"set": {"enableVidoe": true}}]`,
+		`[{"set": {"enableVideo": "yes"}}]`,
+	} {
+		confFile := configfile(data)
+		p := policy.New()
+		assert.Error(t, p.Load(confFile), data)
+		os.Remove(confFile)
+	}
+}
+
+func TestPolicyViolation(t *testing.T) {
+	policies = loadTestPolicies(t, testPolicies)
+	defer func() {
+		policies = policy.New()
+	}()
+	manager = &HTTPTest{Handler: Selenium()}
+
+	rsp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"capabilities":{"alwaysMatch":{"browserName": "firefox", "selenoid:options": {"env": ["A=B"]}}}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusBadRequest)
+	assert.Equal(t, queue.Used(), 0)
+}
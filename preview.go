@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aerokube/selenoid/info"
+	"github.com/aerokube/selenoid/jsonerror"
+	"github.com/aerokube/selenoid/session"
+)
+
+const previewQuality = 75
+
+var (
+	previewsLock sync.Mutex
+	previews     = make(map[string]*previewPoller)
+)
+
+// previewPoller - takes screenshots of one session and sends them as JPEG frames to all viewers
+type previewPoller struct {
+	viewers map[chan []byte]struct{}
+}
+
+// watchPreview - subscribes to session preview frames, channel is closed when session is finished
+func watchPreview(requestId uint64, sid string) (<-chan []byte, func()) {
+	previewsLock.Lock()
+	defer previewsLock.Unlock()
+	p, ok := previews[sid]
+	if !ok {
+		p = &previewPoller{viewers: make(map[chan []byte]struct{})}
+		previews[sid] = p
+		go p.run(requestId, sid)
+	}
+	frames := make(chan []byte, 1)
+	p.viewers[frames] = struct{}{}
+	return frames, func() {
+		previewsLock.Lock()
+		defer previewsLock.Unlock()
+		delete(p.viewers, frames)
+	}
+}
+
+// run - screenshots are taken directly from session so that watching it does not reset session timeout,
+// only the first of repeated failures is logged
+func (p *previewPoller) run(requestId uint64, sid string) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / previewFPS))
+	defer ticker.Stop()
+	failing := false
+	for {
+		sess, ok := sessions.Get(sid)
+		if !ok {
+			p.finish(sid)
+			return
+		}
+		frame, err := previewFrame(sid, sess)
+		switch {
+		case err != nil && !failing:
+			log.Printf("[%d] [PREVIEW_ERROR] [%s] [%v]", requestId, sid, err)
+		case err == nil && failing:
+			log.Printf("[%d] [PREVIEW_RESUMED] [%s]", requestId, sid)
+		}
+		failing = err != nil
+		if !p.broadcast(sid, frame) {
+			return
+		}
+		<-ticker.C
+	}
+}
+
+// broadcast - sends frame to viewers dropping frames not yet received, returns false when nobody is watching
+func (p *previewPoller) broadcast(sid string, frame []byte) bool {
+	previewsLock.Lock()
+	defer previewsLock.Unlock()
+	if len(p.viewers) == 0 {
+		delete(previews, sid)
+		return false
+	}
+	if frame == nil {
+		return true
+	}
+	for viewer := range p.viewers {
+		select {
+		case <-viewer:
+		default:
+		}
+		viewer <- frame
+	}
+	return true
+}
+
+// finish - disconnects all viewers when session is finished
+func (p *previewPoller) finish(sid string) {
+	previewsLock.Lock()
+	defer previewsLock.Unlock()
+	for viewer := range p.viewers {
+		close(viewer)
+		delete(p.viewers, viewer)
+	}
+	delete(previews, sid)
+}
+
+// previewFrame - browser screenshot as JPEG
+func previewFrame(sid string, sess *session.Session) ([]byte, error) {
+	data, err := takeScreenshot(sid, sess)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode screenshot: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: previewQuality}); err != nil {
+		return nil, fmt.Errorf("encode frame: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func preview(w http.ResponseWriter, r *http.Request) {
+	requestId := serial()
+	sid := strings.TrimPrefix(r.URL.Path, paths.Preview)
+	if _, ok := sessions.Get(sid); !ok {
+		jsonerror.InvalidSessionID(fmt.Errorf("unknown session %s", sid)).Encode(w)
+		log.Printf("[%d] [SESSION_NOT_FOUND] [%s]", requestId, sid)
+		return
+	}
+	user, remote := info.RequestInfo(r)
+	log.Printf("[%d] [PREVIEW] [%s] [%s] [%s]", requestId, user, remote, sid)
+	frames, stop := watchPreview(requestId, sid)
+	defer stop()
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Printf("[%d] [PREVIEW_DISCONNECTED] [%s]", requestId, sid)
+			return
+		case frame, ok := <-frames:
+			if !ok {
+				log.Printf("[%d] [PREVIEW_SESSION_FINISHED] [%s]", requestId, sid)
+				return
+			}
+			part, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":   {"image/jpeg"},
+				"Content-Length": {strconv.Itoa(len(frame))},
+			})
+			if err == nil {
+				_, err = part.Write(frame)
+			}
+			if err != nil {
+				log.Printf("[%d] [PREVIEW_DISCONNECTED] [%s] [%v]", requestId, sid, err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/jpeg"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestPreview(t *testing.T) {
+	manager = &HTTPTest{Handler: Selenium()}
+	sessionTimeout := timeout
+	timeout = 5 * time.Second
+	t.Cleanup(func() { timeout = sessionTimeout })
+
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte("{}")))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var sess map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sess))
+	sid := sess["sessionId"]
+	t.Cleanup(func() {
+		if _, ok := sessions.Get(sid); ok {
+			sessions.Remove(sid)
+			queue.Release()
+		}
+	})
+
+	rsp, err := http.Get(With(srv.URL).Path("/preview/" + sid))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	mediaType, params, err := mime.ParseMediaType(rsp.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, mediaType, "multipart/x-mixed-replace")
+
+	mr := multipart.NewReader(rsp.Body, params["boundary"])
+	for i := 0; i < 2; i++ {
+		part, err := mr.NextPart()
+		assert.NoError(t, err)
+		assert.Equal(t, part.Header.Get("Content-Type"), "image/jpeg")
+		img, err := jpeg.Decode(part)
+		assert.NoError(t, err)
+		assert.Equal(t, img.Bounds().Dx(), 4)
+	}
+
+	sessions.Remove(sid)
+	queue.Release()
+	_, err = io.ReadAll(rsp.Body)
+	assert.NoError(t, err)
+	waitFor(t, func() bool {
+		previewsLock.Lock()
+		defer previewsLock.Unlock()
+		return len(previews) == 0
+	})
+}
+
+func TestPreviewMissingSession(t *testing.T) {
+	rsp, err := http.Get(With(srv.URL).Path("/preview/missing-session"))
+	assert.NoError(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusNotFound)
+}
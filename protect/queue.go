@@ -6,6 +6,7 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/aerokube/selenoid/jsonerror"
@@ -18,12 +19,17 @@ type Queue struct {
 	queued   chan struct{}
 	pending  chan struct{}
 	used     chan struct{}
+	draining atomic.Bool
 }
 
 // Try - when X-Selenoid-No-Wait header is set
 // reply to client immediately if queue is full
 func (q *Queue) Try(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if q.Draining() {
+			rejectDraining(w, r)
+			return
+		}
 		_, noWait := r.Header["X-Selenoid-No-Wait"]
 		select {
 		case q.limit <- struct{}{}:
@@ -76,11 +82,35 @@ func (q *Queue) Protect(next http.HandlerFunc) http.HandlerFunc {
 			q.pending <- struct{}{}
 		}
 		<-q.queued
+		// Drain could be started while request was waiting in queue
+		if q.Draining() {
+			q.Drop()
+			rejectDraining(w, r)
+			return
+		}
 		log.Printf("[-] [NEW_REQUEST_ACCEPTED] [%s] [%s]", user, remote)
 		next.ServeHTTP(w, r)
 	}
 }
 
+func rejectDraining(w http.ResponseWriter, r *http.Request) {
+	user, remote := info.RequestInfo(r)
+	log.Printf("[-] [QUEUE_IS_DRAINING] [%s] [%s]", user, remote)
+	err := jsonerror.UnknownError(errors.New("selenoid is draining and does not accept new sessions"))
+	err.Status = http.StatusTooManyRequests
+	err.Encode(w)
+}
+
+// Drain - stop or resume accepting new sessions
+func (q *Queue) Drain(draining bool) {
+	q.draining.Store(draining)
+}
+
+// Draining - whether new sessions are rejected
+func (q *Queue) Draining() bool {
+	return q.draining.Load()
+}
+
 // Used - get created sessions
 func (q *Queue) Used() int {
 	return len(q.used)
@@ -116,10 +146,10 @@ func (q *Queue) Release() {
 // New - create and initialize queue
 func New(size int, disabled bool) *Queue {
 	return &Queue{
-		disabled,
-		make(chan struct{}, size),
-		make(chan struct{}, math.MaxInt32),
-		make(chan struct{}, math.MaxInt32),
-		make(chan struct{}, math.MaxInt32),
+		disabled: disabled,
+		limit:    make(chan struct{}, size),
+		queued:   make(chan struct{}, math.MaxInt32),
+		pending:  make(chan struct{}, math.MaxInt32),
+		used:     make(chan struct{}, math.MaxInt32),
 	}
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+	"github.com/aerokube/selenoid/rfb"
+)
+
+const (
+	// Screen updates are requested with this frame rate unless videoFrameRate capability is set
+	vncRecordingFrameRate = 5
+	// VNC server is started together with browser and can be not yet listening when session is created
+	vncRecordingConnectTimeout = 5 * time.Second
+)
+
+var (
+	vncRecordingsLock sync.Mutex
+	vncRecordings     = make(map[string]*vncRecording)
+)
+
+// vncRecording - records screen updates of one session to temporary file in video output directory
+type vncRecording struct {
+	name   string
+	cancel func()
+	done   chan struct{}
+}
+
+// startVNCRecording - connects to VNC server of session and records screen until session is stopped
+func startVNCRecording(requestId uint64, sid string, vnc string, frameRate uint16) error {
+	if err := os.MkdirAll(videoOutputDir, os.FileMode(0755)); err != nil {
+		return fmt.Errorf("create video output dir: %v", err)
+	}
+	name := filepath.Join(videoOutputDir, getTemporaryFileName(videoOutputDir, vncRecordingFileExtension))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("create VNC recording: %v", err)
+	}
+	if frameRate == 0 {
+		frameRate = vncRecordingFrameRate
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := &vncRecording{name: name, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(rec.done)
+		gz := gzip.NewWriter(f)
+		err := rec.record(ctx, gz, vnc, time.Second/time.Duration(frameRate))
+		if ctx.Err() == nil && err != nil {
+			log.Printf("[%d] [VNC_RECORDING_ERROR] [%s] [%v]", requestId, sid, err)
+		}
+		if err := gz.Close(); err != nil {
+			log.Printf("[%d] [VNC_RECORDING_ERROR] [%s] [%v]", requestId, sid, err)
+		}
+		_ = f.Close()
+	}()
+	vncRecordingsLock.Lock()
+	defer vncRecordingsLock.Unlock()
+	vncRecordings[sid] = rec
+	return nil
+}
+
+// vncRecordingFiles - temporary files of running sessions
+func vncRecordingFiles() []string {
+	vncRecordingsLock.Lock()
+	defer vncRecordingsLock.Unlock()
+	var ret []string
+	for _, rec := range vncRecordings {
+		ret = append(ret, rec.name)
+	}
+	return ret
+}
+
+func dialVNC(ctx context.Context, vnc string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, vncRecordingConnectTimeout)
+	defer cancel()
+	var d net.Dialer
+	for {
+		conn, err := d.DialContext(ctx, "tcp", vnc)
+		if err == nil {
+			return conn, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("connect to VNC server: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// record - requests screen updates not more often than given interval and writes them to FBS file
+func (rec *vncRecording) record(ctx context.Context, gz *gzip.Writer, vnc string, interval time.Duration) error {
+	conn, err := dialVNC(ctx, vnc)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	c, err := rfb.Handshake(conn, vncPassword)
+	if err != nil {
+		return fmt.Errorf("VNC handshake: %v", err)
+	}
+	w, err := rfb.NewWriter(gz, c.ServerInit)
+	if err != nil {
+		return err
+	}
+	if err := c.RequestUpdate(false); err != nil {
+		return err
+	}
+	requested := time.Now()
+	for {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if err := w.WriteMessage(msg); err != nil {
+			return err
+		}
+		if msg[0] != rfb.FramebufferUpdate {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(requested.Add(interval))):
+		}
+		if err := c.RequestUpdate(true); err != nil {
+			return err
+		}
+		requested = time.Now()
+	}
+}
+
+// stop - stops recording and waits until recorded data is written
+func (rec *vncRecording) stop() {
+	rec.cancel()
+	<-rec.done
+}
+
+// stopVNCRecording - stops recording of session without removing it, so that it can be saved later
+func stopVNCRecording(sid string) {
+	vncRecordingsLock.Lock()
+	rec, ok := vncRecordings[sid]
+	vncRecordingsLock.Unlock()
+	if ok {
+		rec.stop()
+	}
+}
+
+// saveVNCRecording - stops recording, renames recording of finished session and notifies listeners
+func saveVNCRecording(e event.Event, sid string) {
+	vncRecordingsLock.Lock()
+	rec, ok := vncRecordings[sid]
+	delete(vncRecordings, sid)
+	vncRecordingsLock.Unlock()
+	if !ok {
+		return
+	}
+	rec.stop()
+	newName := filepath.Join(videoOutputDir, e.SessionId+vncRecordingFileExtension)
+	if err := os.Rename(rec.name, newName); err != nil {
+		log.Printf("[%d] [VNC_RECORDING_ERROR] [%s]", e.RequestId, fmt.Sprintf("Failed to rename %s to %s: %v", rec.name, newName, err))
+		return
+	}
+	event.FileCreated(event.CreatedFile{
+		Event: e,
+		Name:  newName,
+		Type:  vncRecordingFileType,
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/des"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aerokube/selenoid/event"
+	"github.com/aerokube/selenoid/rfb"
+	"github.com/aerokube/selenoid/session"
+	assert "github.com/stretchr/testify/require"
+)
+
+// rfbServerStub - VNC server with password authentication sending two screen updates of 4x2 screen
+func rfbServerStub(l net.Listener, password string) <-chan []byte {
+	clientMessages := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		read := func(n int) []byte {
+			buf := make([]byte, n)
+			_, _ = io.ReadFull(conn, buf)
+			return buf
+		}
+		write := func(data ...interface{}) {
+			for _, d := range data {
+				_ = binary.Write(conn, binary.BigEndian, d)
+			}
+		}
+		write([]byte("RFB 003.008\n"))
+		if string(read(12)) != "RFB 003.008\n" {
+			return
+		}
+		write([]byte{1, rfb.SecurityVNC})
+		if read(1)[0] != rfb.SecurityVNC {
+			return
+		}
+		challenge := bytes.Repeat([]byte{0x5A}, 16)
+		write(challenge)
+		key := make([]byte, 8)
+		copy(key, password)
+		for i := range key {
+			key[i] = bits.Reverse8(key[i])
+		}
+		cipher, _ := des.NewCipher(key)
+		expected := make([]byte, 16)
+		cipher.Encrypt(expected, challenge)
+		cipher.Encrypt(expected[8:], challenge[8:])
+		if !bytes.Equal(read(16), expected) {
+			write(uint32(1), uint32(6), []byte("denied"))
+			return
+		}
+		write(uint32(0))
+		shared := read(1)
+		// Native pixel format is 16-bit, client has to request 32-bit one
+		write(uint16(4), uint16(2), []byte{16, 16, 0, 1, 0, 31, 0, 63, 0, 31, 11, 5, 0, 0, 0, 0}, uint32(4), []byte("test"))
+		messages := read(20 + 4 + 12)
+		received := append(shared, messages...)
+		if read(10)[1] != 0 {
+			return
+		}
+		// Left half is blue and right half is red
+		write([]byte{rfb.FramebufferUpdate, 0}, uint16(1), uint16(0), uint16(0), uint16(4), uint16(2), int32(rfb.EncodingRaw))
+		write(bytes.Repeat([]byte{0xFF, 0, 0, 0, 0xFF, 0, 0, 0, 0, 0, 0xFF, 0, 0, 0, 0xFF, 0}, 2))
+		if read(10)[1] != 1 {
+			return
+		}
+		write([]byte{rfb.Bell})
+		// Left half is copied to the right one and the first pixel becomes green
+		write([]byte{rfb.FramebufferUpdate, 0}, uint16(2), uint16(2), uint16(0), uint16(2), uint16(2), int32(rfb.EncodingCopyRect), uint16(0), uint16(0))
+		write(uint16(0), uint16(0), uint16(1), uint16(1), int32(rfb.EncodingRaw), []byte{0, 0xFF, 0, 0})
+		// Next request is sent after the update is recorded
+		read(10)
+		clientMessages <- received
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	return clientMessages
+}
+
+func TestVNCRecording(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	received := rfbServerStub(l, vncPassword)
+
+	sid := "vnc-recording"
+	assert.NoError(t, startVNCRecording(serial(), sid, l.Addr().String(), 50))
+	assert.Len(t, vncRecordingFiles(), 1)
+	assert.Equal(t, <-received, []byte{
+		1,
+		rfb.SetPixelFormat, 0, 0, 0, 32, 24, 0, 1, 0, 255, 0, 255, 0, 255, 16, 8, 0, 0, 0, 0,
+		rfb.SetEncodings, 0, 0, 3, 0, 0, 0, 1, 0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0x21,
+	})
+	stopVNCRecording(sid)
+	assert.Len(t, vncRecordingFiles(), 1)
+	saveVNCRecording(event.Event{RequestId: serial(), SessionId: sid, Session: &session.Session{}}, sid)
+	assert.Empty(t, vncRecordingFiles())
+
+	name := filepath.Join(videoOutputDir, sid+vncRecordingFileExtension)
+	defer os.Remove(name)
+	f, err := os.Open(name)
+	assert.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	p, err := rfb.NewPlayer(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, p.Name, "test")
+	red, green, blue := color.RGBA{R: 0xFF, A: 0xFF}, color.RGBA{G: 0xFF, A: 0xFF}, color.RGBA{B: 0xFF, A: 0xFF}
+
+	_, err = p.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, p.RGBAAt(1, 1), blue)
+	assert.Equal(t, p.RGBAAt(3, 1), red)
+	p.Damage = image.Rectangle{}
+
+	_, err = p.Next()
+	assert.NoError(t, err)
+	assert.True(t, p.Damage.Empty())
+	_, err = p.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, p.RGBAAt(0, 0), green)
+	assert.Equal(t, p.RGBAAt(1, 0), blue)
+	assert.Equal(t, p.RGBAAt(3, 1), blue)
+	assert.Equal(t, p.Damage, p.Bounds())
+	_, err = p.Next()
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestVNCRecordingAuthenticationFailed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	rfbServerStub(l, "other")
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = rfb.Handshake(conn, vncPassword)
+	assert.EqualError(t, err, "denied")
+}
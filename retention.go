@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+	"github.com/aerokube/selenoid/session"
+	"github.com/aerokube/selenoid/upload"
+	"github.com/docker/go-units"
+)
+
+// Files modified recently are considered being written
+const retentionGracePeriod = time.Minute
+
+// byteSize - size flag accepting values like 500m or 10g
+type byteSize int64
+
+func (s *byteSize) String() string {
+	return units.BytesSize(float64(*s))
+}
+
+func (s *byteSize) Set(v string) error {
+	size, err := units.RAMInBytes(v)
+	if err != nil {
+		return fmt.Errorf("set size: %v", err)
+	}
+	*s = byteSize(size)
+	return nil
+}
+
+// Retention - limits applied to every output directory, zero values mean no limit
+type Retention struct {
+	MaxAge   time.Duration
+	MaxSize  int64
+	MaxFiles int
+}
+
+func (r Retention) Enabled() bool {
+	return r.MaxAge > 0 || r.MaxSize > 0 || r.MaxFiles > 0
+}
+
+func (r Retention) String() string {
+	return fmt.Sprintf("maxAge = %s, maxSize = %s, maxFiles = %d", r.MaxAge, units.BytesSize(float64(r.MaxSize)), r.MaxFiles)
+}
+
+type retainedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// retain - delete oldest files exceeding retention limits, busy files are never deleted
+func retain(dir string, r Retention, busy map[string]struct{}, now time.Time, deleted func(path string, reason string)) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var files []retainedFile
+	var totalSize int64
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, retainedFile{
+			path:    filepath.Join(dir, e.Name()),
+			size:    fi.Size(),
+			modTime: fi.ModTime(),
+		})
+		totalSize += fi.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	totalFiles := len(files)
+	for _, f := range files {
+		reason := ""
+		switch {
+		case r.MaxAge > 0 && now.Sub(f.modTime) > r.MaxAge:
+			reason = fmt.Sprintf("older than %s", r.MaxAge)
+		case r.MaxSize > 0 && totalSize > r.MaxSize:
+			reason = fmt.Sprintf("total size exceeds %s", units.BytesSize(float64(r.MaxSize)))
+		case r.MaxFiles > 0 && totalFiles > r.MaxFiles:
+			reason = fmt.Sprintf("more than %d files", r.MaxFiles)
+		default:
+			continue
+		}
+		if _, ok := busy[f.path]; ok || now.Sub(f.modTime) < retentionGracePeriod {
+			continue
+		}
+		err := os.Remove(f.path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("[-] [RETENTION_ERROR] [Failed to delete %s: %v]", f.path, err)
+			continue
+		}
+		totalSize -= f.size
+		totalFiles--
+		deleted(f.path, reason)
+	}
+	return nil
+}
+
+// busyFiles - files of running sessions and files waiting for upload
+func busyFiles() map[string]struct{} {
+	ret := make(map[string]struct{})
+	sessions.Each(func(_ string, sess *session.Session) {
+		if sess.Caps.VideoName != "" {
+			ret[filepath.Join(videoOutputDir, sess.Caps.VideoName)] = struct{}{}
+		}
+		if logOutputDir != "" && sess.Caps.LogName != "" {
+			ret[filepath.Join(logOutputDir, sess.Caps.LogName)] = struct{}{}
+		}
+	})
+	for _, name := range append(append(commandLogFiles(), consoleLogFiles()...), vncRecordingFiles()...) {
+		ret[name] = struct{}{}
+	}
+	if status, ok := upload.QueueStatus(); ok {
+		for _, tasks := range [][]upload.Task{status.Pending, status.Failed} {
+			for _, t := range tasks {
+				ret[t.Name] = struct{}{}
+			}
+		}
+	}
+	return ret
+}
+
+func enforceRetention() {
+	busy := busyFiles()
+	dirs := []string{}
+	if !disableDocker {
+		dirs = append(dirs, videoOutputDir)
+	}
+	if logOutputDir != "" {
+		dirs = append(dirs, logOutputDir)
+	}
+	if screenshotOutputDir != "" {
+		dirs = append(dirs, screenshotOutputDir)
+	}
+	now := time.Now()
+	for _, dir := range dirs {
+		err := retain(dir, retention, busy, now, func(path string, reason string) {
+			log.Printf("[-] [RETENTION_DELETED_FILE] [%s] [%s]", path, reason)
+			event.FileDeleted(event.DeletedFile{
+				Event:  event.Event{Session: &session.Session{}},
+				Name:   path,
+				Type:   fileTypeOf(path),
+				Reason: reason,
+			})
+		})
+		if err != nil {
+			log.Printf("[-] [RETENTION_ERROR] [Failed to list %s: %v]", dir, err)
+		}
+	}
+}
+
+func startJanitor(interval time.Duration) {
+	if !retention.Enabled() {
+		return
+	}
+	log.Printf("[-] [INIT] [Enforcing retention every %s: %s]", interval, retention)
+	go func() {
+		for range time.Tick(interval) {
+			enforceRetention()
+		}
+	}()
+}
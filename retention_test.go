@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func testRetentionDir(t *testing.T, now time.Time, files map[string]time.Duration) string {
+	dir, err := os.MkdirTemp("", "selenoid-retention")
+	assert.NoError(t, err)
+	for name, age := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, make([]byte, 100), 0644))
+		mtime := now.Add(-age)
+		assert.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	return dir
+}
+
+func retainedFiles(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	var ret []string
+	for _, e := range entries {
+		ret = append(ret, e.Name())
+	}
+	return ret
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	now := time.Now()
+	dir := testRetentionDir(t, now, map[string]time.Duration{
+		"old.mp4":     48 * time.Hour,
+		"busy.mp4":    48 * time.Hour,
+		"new.mp4":     time.Hour,
+		".hidden.tmp": 48 * time.Hour,
+	})
+	defer os.RemoveAll(dir)
+
+	var deleted []string
+	busy := map[string]struct{}{filepath.Join(dir, "busy.mp4"): {}}
+	err := retain(dir, Retention{MaxAge: 24 * time.Hour}, busy, now, func(path string, reason string) {
+		deleted = append(deleted, filepath.Base(path))
+		assert.Equal(t, reason, "older than 24h0m0s")
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, deleted, []string{"old.mp4"})
+	assert.Equal(t, retainedFiles(t, dir), []string{".hidden.tmp", "busy.mp4", "new.mp4"})
+}
+
+func TestRetentionMaxSizeAndFiles(t *testing.T) {
+	now := time.Now()
+	dir := testRetentionDir(t, now, map[string]time.Duration{
+		"1.log":         5 * time.Hour,
+		"2.log":         4 * time.Hour,
+		"3.log":         3 * time.Hour,
+		"4.log":         2 * time.Hour,
+		"being-written": time.Second,
+	})
+	defer os.RemoveAll(dir)
+
+	noop := func(string, string) {}
+	assert.NoError(t, retain(dir, Retention{MaxSize: 400}, nil, now, noop))
+	assert.Equal(t, retainedFiles(t, dir), []string{"2.log", "3.log", "4.log", "being-written"})
+
+	assert.NoError(t, retain(dir, Retention{MaxFiles: 1}, nil, now, noop))
+	assert.Equal(t, retainedFiles(t, dir), []string{"being-written"})
+}
+
+func TestByteSizeFlag(t *testing.T) {
+	var size byteSize
+	assert.NoError(t, size.Set("10m"))
+	assert.Equal(t, int64(size), int64(10*1024*1024))
+	assert.Error(t, size.Set("wrong"))
+	assert.Equal(t, fileTypeOf("/path/to/video.mp4"), "video")
+	assert.Equal(t, fileTypeOf("/path/to/session.json"), "metadata")
+}
@@ -0,0 +1,112 @@
+package rfb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// FBS 1.0 file stores server-to-client RFB stream as blocks of data with millisecond timestamps.
+// Recordings start with RFB 3.3 handshake without authentication and ServerInit in recording pixel format,
+// so that they can be replayed by tools reading FBS files.
+const fbsHeader = "FBS 001.000\n"
+
+// Writer - writes server messages to FBS file
+type Writer struct {
+	w       io.Writer
+	started time.Time
+}
+
+// NewWriter - writes FBS and RFB headers for given framebuffer parameters
+func NewWriter(w io.Writer, si ServerInit) (*Writer, error) {
+	if _, err := io.WriteString(w, fbsHeader); err != nil {
+		return nil, err
+	}
+	fw := &Writer{w: w, started: time.Now()}
+	handshake := append([]byte("RFB 003.003\n"), 0, 0, 0, SecurityNone)
+	if err := fw.WriteMessage(append(handshake, si.bytes()...)); err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+// WriteMessage - writes data as one block timestamped with time since recording started
+func (fw *Writer) WriteMessage(data []byte) error {
+	block := make([]byte, 4, 8+len(data)+3)
+	binary.BigEndian.PutUint32(block, uint32(len(data)))
+	block = append(block, data...)
+	for len(block)%4 != 0 {
+		block = append(block, 0)
+	}
+	block = binary.BigEndian.AppendUint32(block, uint32(time.Since(fw.started).Milliseconds()))
+	_, err := fw.w.Write(block)
+	return err
+}
+
+// blockReader - reads RFB stream from FBS blocks remembering timestamp of the last read block
+type blockReader struct {
+	r         *bufio.Reader
+	data      []byte
+	timestamp time.Duration
+}
+
+func (br *blockReader) Read(p []byte) (int, error) {
+	for len(br.data) == 0 {
+		var length uint32
+		if err := binary.Read(br.r, binary.BigEndian, &length); err != nil {
+			return 0, err
+		}
+		block := make([]byte, (length+3)/4*4+4)
+		if _, err := io.ReadFull(br.r, block); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		br.data = block[:length]
+		br.timestamp = time.Duration(binary.BigEndian.Uint32(block[len(block)-4:])) * time.Millisecond
+	}
+	n := copy(p, br.data)
+	br.data = br.data[n:]
+	return n, nil
+}
+
+// Player - replays FBS recording restoring framebuffer contents
+type Player struct {
+	*Framebuffer
+	ServerInit
+	r *blockReader
+}
+
+// NewPlayer - reads FBS and RFB headers, only recordings in 32-bit true colour pixel format without authentication are supported
+func NewPlayer(r io.Reader) (*Player, error) {
+	br := &blockReader{r: bufio.NewReader(r)}
+	header := make([]byte, len(fbsHeader))
+	if _, err := io.ReadFull(br.r, header); err != nil || string(header) != fbsHeader {
+		return nil, errors.New("not an FBS 1.0 file")
+	}
+	handshake := make([]byte, 16)
+	if _, err := io.ReadFull(br, handshake); err != nil {
+		return nil, fmt.Errorf("read handshake: %v", err)
+	}
+	if string(handshake[:12]) != "RFB 003.003\n" || binary.BigEndian.Uint32(handshake[12:]) != SecurityNone {
+		return nil, fmt.Errorf("unsupported handshake %q", handshake)
+	}
+	si, err := readServerInit(br)
+	if err != nil {
+		return nil, fmt.Errorf("read server init: %v", err)
+	}
+	if pf := si.PixelFormat; pf.BitsPerPixel != 32 || pf.TrueColour == 0 {
+		return nil, fmt.Errorf("unsupported pixel format: %d bits per pixel, true colour %d", pf.BitsPerPixel, pf.TrueColour)
+	}
+	return &Player{Framebuffer: newFramebuffer(si), ServerInit: si, r: br}, nil
+}
+
+// Next - applies next server message to framebuffer and returns its time since recording started, io.EOF is returned at the end of recording
+func (p *Player) Next() (time.Duration, error) {
+	err := readMessage(p.r, &p.ServerInit, p.Framebuffer)
+	if err == io.ErrUnexpectedEOF {
+		return 0, fmt.Errorf("truncated recording: %v", err)
+	}
+	return p.r.timestamp, err
+}
@@ -0,0 +1,64 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// Framebuffer - screen contents restored from framebuffer updates
+type Framebuffer struct {
+	*image.RGBA
+	pixelFormat PixelFormat
+	// Damage - part of screen changed since it was last reset
+	Damage image.Rectangle
+}
+
+func newFramebuffer(si ServerInit) *Framebuffer {
+	fb := &Framebuffer{pixelFormat: si.PixelFormat}
+	fb.resize(int(si.Width), int(si.Height))
+	return fb
+}
+
+func (fb *Framebuffer) resize(width int, height int) {
+	fb.RGBA = image.NewRGBA(image.Rect(0, 0, width, height))
+	fb.Damage = fb.Bounds()
+}
+
+func (fb *Framebuffer) damage(r image.Rectangle) {
+	fb.Damage = fb.Damage.Union(r.Intersect(fb.Bounds()))
+}
+
+func (fb *Framebuffer) raw(rect rectangle, pixels []byte) {
+	pf := fb.pixelFormat
+	var order binary.ByteOrder = binary.LittleEndian
+	if pf.BigEndian != 0 {
+		order = binary.BigEndian
+	}
+	channel := func(v uint32, shift uint8, max uint16) uint8 {
+		if max == 0 {
+			return 0
+		}
+		return uint8((v >> shift & uint32(max)) * 255 / uint32(max))
+	}
+	x0, y0, w := int(rect.X), int(rect.Y), int(rect.Width)
+	for i := 0; i+4 <= len(pixels); i += 4 {
+		x, y := x0+i/4%w, y0+i/4/w
+		if !(image.Point{X: x, Y: y}.In(fb.Bounds())) {
+			continue
+		}
+		v := order.Uint32(pixels[i:])
+		o := fb.PixOffset(x, y)
+		fb.Pix[o] = channel(v, pf.RedShift, pf.RedMax)
+		fb.Pix[o+1] = channel(v, pf.GreenShift, pf.GreenMax)
+		fb.Pix[o+2] = channel(v, pf.BlueShift, pf.BlueMax)
+		fb.Pix[o+3] = 0xFF
+	}
+	fb.damage(image.Rect(x0, y0, x0+w, y0+int(rect.Height)))
+}
+
+func (fb *Framebuffer) copyRect(rect rectangle, srcX int, srcY int) {
+	r := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height))
+	draw.Draw(fb.RGBA, r, fb.RGBA, image.Pt(srcX, srcY), draw.Src)
+	fb.damage(r)
+}
@@ -0,0 +1,374 @@
+// Package rfb implements client side of RFB (VNC) protocol needed to record browser screen, see RFC 6143
+package rfb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/des"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Client-to-server message types
+const (
+	SetPixelFormat           = 0
+	SetEncodings             = 2
+	FramebufferUpdateRequest = 3
+	KeyEvent                 = 4
+	PointerEvent             = 5
+	ClientCutText            = 6
+)
+
+// Server-to-client message types
+const (
+	FramebufferUpdate   = 0
+	SetColourMapEntries = 1
+	Bell                = 2
+	ServerCutText       = 3
+)
+
+// Security types
+const (
+	SecurityNone = 1
+	SecurityVNC  = 2
+)
+
+// Encodings requested by recorder, other encodings are not supported
+const (
+	EncodingRaw         = 0
+	EncodingCopyRect    = 1
+	EncodingDesktopSize = -223
+)
+
+// PixelFormat - pixel format as sent in ServerInit and SetPixelFormat messages
+type PixelFormat struct {
+	BitsPerPixel uint8
+	Depth        uint8
+	BigEndian    uint8
+	TrueColour   uint8
+	RedMax       uint16
+	GreenMax     uint16
+	BlueMax      uint16
+	RedShift     uint8
+	GreenShift   uint8
+	BlueShift    uint8
+	_            [3]byte
+}
+
+// RecordingPixelFormat - 32-bit true colour pixel format requested by recorder
+var RecordingPixelFormat = PixelFormat{
+	BitsPerPixel: 32,
+	Depth:        24,
+	TrueColour:   1,
+	RedMax:       255,
+	GreenMax:     255,
+	BlueMax:      255,
+	RedShift:     16,
+	GreenShift:   8,
+	BlueShift:    0,
+}
+
+// ServerInit - framebuffer parameters sent by server after handshake
+type ServerInit struct {
+	Width       uint16
+	Height      uint16
+	PixelFormat PixelFormat
+	Name        string
+}
+
+func (si ServerInit) bytes() []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, struct {
+		Width, Height uint16
+		PixelFormat   PixelFormat
+		NameLength    uint32
+	}{si.Width, si.Height, si.PixelFormat, uint32(len(si.Name))})
+	buf.WriteString(si.Name)
+	return buf.Bytes()
+}
+
+func readServerInit(r io.Reader) (ServerInit, error) {
+	var header struct {
+		Width, Height uint16
+		PixelFormat   PixelFormat
+		NameLength    uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return ServerInit{}, err
+	}
+	name := make([]byte, header.NameLength)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return ServerInit{}, err
+	}
+	return ServerInit{Width: header.Width, Height: header.Height, PixelFormat: header.PixelFormat, Name: string(name)}, nil
+}
+
+// Conn - client connection receiving framebuffer updates in recording pixel format
+type Conn struct {
+	ServerInit
+	w io.Writer
+	r *bufio.Reader
+}
+
+// Handshake - authenticates with no or VNC password authentication and requests recording pixel format and encodings
+func Handshake(rw io.ReadWriter, password string) (*Conn, error) {
+	r := bufio.NewReader(rw)
+	version := make([]byte, 12)
+	if _, err := io.ReadFull(r, version); err != nil {
+		return nil, fmt.Errorf("read protocol version: %v", err)
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(string(version), "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 || minor < 3 {
+		return nil, fmt.Errorf("unsupported protocol version %q", version)
+	}
+	switch {
+	case minor >= 8:
+		minor = 8
+	case minor == 7:
+	default:
+		minor = 3
+	}
+	if _, err := fmt.Fprintf(rw, "RFB 003.%03d\n", minor); err != nil {
+		return nil, err
+	}
+	security, err := negotiateSecurity(rw, r, minor)
+	if err != nil {
+		return nil, err
+	}
+	if security == SecurityVNC {
+		challenge := make([]byte, 16)
+		if _, err := io.ReadFull(r, challenge); err != nil {
+			return nil, fmt.Errorf("read challenge: %v", err)
+		}
+		response, err := encryptChallenge(challenge, password)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := rw.Write(response); err != nil {
+			return nil, err
+		}
+	}
+	if security == SecurityVNC || minor >= 8 {
+		var result uint32
+		if err := binary.Read(r, binary.BigEndian, &result); err != nil {
+			return nil, fmt.Errorf("read security result: %v", err)
+		}
+		if result != 0 {
+			reason := "authentication failed"
+			if minor >= 8 {
+				reason = readReason(r, reason)
+			}
+			return nil, errors.New(reason)
+		}
+	}
+	// ClientInit with shared flag not to disconnect other viewers
+	if _, err := rw.Write([]byte{1}); err != nil {
+		return nil, err
+	}
+	si, err := readServerInit(r)
+	if err != nil {
+		return nil, fmt.Errorf("read server init: %v", err)
+	}
+	si.PixelFormat = RecordingPixelFormat
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, struct {
+		Type        uint8
+		_           [3]byte
+		PixelFormat PixelFormat
+	}{Type: SetPixelFormat, PixelFormat: RecordingPixelFormat})
+	encodings := []int32{EncodingCopyRect, EncodingRaw, EncodingDesktopSize}
+	_ = binary.Write(&buf, binary.BigEndian, struct {
+		Type  uint8
+		_     uint8
+		Count uint16
+	}{Type: SetEncodings, Count: uint16(len(encodings))})
+	_ = binary.Write(&buf, binary.BigEndian, encodings)
+	if _, err := rw.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return &Conn{ServerInit: si, w: rw, r: r}, nil
+}
+
+func negotiateSecurity(w io.Writer, r *bufio.Reader, minor int) (uint8, error) {
+	if minor == 3 {
+		var security uint32
+		if err := binary.Read(r, binary.BigEndian, &security); err != nil {
+			return 0, fmt.Errorf("read security type: %v", err)
+		}
+		if security == 0 {
+			return 0, errors.New(readReason(r, "connection failed"))
+		}
+		if security != SecurityNone && security != SecurityVNC {
+			return 0, fmt.Errorf("unsupported security type %d", security)
+		}
+		return uint8(security), nil
+	}
+	count, err := r.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("read security types: %v", err)
+	}
+	if count == 0 {
+		return 0, errors.New(readReason(r, "connection failed"))
+	}
+	types := make([]byte, count)
+	if _, err := io.ReadFull(r, types); err != nil {
+		return 0, fmt.Errorf("read security types: %v", err)
+	}
+	var security uint8
+	for _, t := range types {
+		if t == SecurityNone || (t == SecurityVNC && security == 0) {
+			security = t
+		}
+	}
+	if security == 0 {
+		return 0, fmt.Errorf("unsupported security types %v", types)
+	}
+	_, err = w.Write([]byte{security})
+	return security, err
+}
+
+func readReason(r io.Reader, fallback string) string {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil || length > 1<<16 {
+		return fallback
+	}
+	reason := make([]byte, length)
+	if _, err := io.ReadFull(r, reason); err != nil {
+		return fallback
+	}
+	return string(reason)
+}
+
+// encryptChallenge - VNC authentication response, password bytes are used as DES key with reversed bit order
+func encryptChallenge(challenge []byte, password string) ([]byte, error) {
+	key := make([]byte, 8)
+	copy(key, password)
+	for i, b := range key {
+		var reversed byte
+		for j := 0; j < 8; j++ {
+			reversed |= (b >> j & 1) << (7 - j)
+		}
+		key[i] = reversed
+	}
+	cipher, err := des.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	response := make([]byte, len(challenge))
+	for i := 0; i+8 <= len(challenge); i += 8 {
+		cipher.Encrypt(response[i:], challenge[i:])
+	}
+	return response, nil
+}
+
+// RequestUpdate - asks server to send framebuffer update of the whole screen
+func (c *Conn) RequestUpdate(incremental bool) error {
+	var flag uint8
+	if incremental {
+		flag = 1
+	}
+	return binary.Write(c.w, binary.BigEndian, struct {
+		Type, Incremental   uint8
+		X, Y, Width, Height uint16
+	}{FramebufferUpdateRequest, flag, 0, 0, c.Width, c.Height})
+}
+
+// ReadMessage - reads one complete server message
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := readMessage(io.TeeReader(c.r, &buf), &c.ServerInit, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readMessage - reads server message sent in recording pixel format and applies it to framebuffer if given, framebuffer size is updated on desktop resize
+func readMessage(r io.Reader, si *ServerInit, fb *Framebuffer) error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header[:1]); err != nil {
+		return err
+	}
+	switch header[0] {
+	case FramebufferUpdate:
+		if _, err := io.ReadFull(r, header[1:4]); err != nil {
+			return err
+		}
+		for n := binary.BigEndian.Uint16(header[2:]); n > 0; n-- {
+			var rect rectangle
+			if err := binary.Read(r, binary.BigEndian, &rect); err != nil {
+				return err
+			}
+			var err error
+			switch rect.Encoding {
+			case EncodingRaw:
+				size := int64(rect.Width) * int64(rect.Height) * int64(si.PixelFormat.BitsPerPixel/8)
+				if fb == nil {
+					err = skip(r, size)
+					break
+				}
+				pixels := make([]byte, size)
+				if _, err = io.ReadFull(r, pixels); err == nil {
+					fb.raw(rect, pixels)
+				}
+			case EncodingCopyRect:
+				var src struct{ X, Y uint16 }
+				if err = binary.Read(r, binary.BigEndian, &src); err == nil && fb != nil {
+					fb.copyRect(rect, int(src.X), int(src.Y))
+				}
+			case EncodingDesktopSize:
+				si.Width, si.Height = rect.Width, rect.Height
+				if fb != nil {
+					fb.resize(int(rect.Width), int(rect.Height))
+				}
+			default:
+				return fmt.Errorf("unsupported encoding %d", rect.Encoding)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case SetColourMapEntries:
+		var count uint16
+		if _, err := io.ReadFull(r, header[1:]); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+			return err
+		}
+		if err := skip(r, 6*int64(count)); err != nil {
+			return err
+		}
+	case Bell:
+	case ServerCutText:
+		var length uint32
+		if _, err := io.ReadFull(r, header[1:]); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return err
+		}
+		if err := skip(r, int64(length)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported message type %d", header[0])
+	}
+	return nil
+}
+
+// skip - discards message data, message ending before all data is read is reported as truncated
+func skip(r io.Reader, n int64) error {
+	_, err := io.CopyN(io.Discard, r, n)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// rectangle - header of rectangle in framebuffer update
+type rectangle struct {
+	X, Y, Width, Height uint16
+	Encoding            int32
+}
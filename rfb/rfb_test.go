@@ -0,0 +1,238 @@
+package rfb
+
+import (
+	"bytes"
+	"crypto/des"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+var (
+	red   = color.RGBA{R: 0xFF, A: 0xFF}
+	green = color.RGBA{G: 0xFF, A: 0xFF}
+	blue  = color.RGBA{B: 0xFF, A: 0xFF}
+
+	// Pixels in little endian recording pixel format
+	redPixel   = []byte{0, 0, 0xFF, 0}
+	greenPixel = []byte{0, 0xFF, 0, 0}
+	bluePixel  = []byte{0xFF, 0, 0, 0}
+)
+
+// message - concatenates big endian representations of values
+func message(data ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, d := range data {
+		_ = binary.Write(&buf, binary.BigEndian, d)
+	}
+	return buf.Bytes()
+}
+
+// serverConn - connection reading prepared server data and saving everything written by client
+func serverConn(data ...[]byte) (io.ReadWriter, *bytes.Buffer) {
+	written := &bytes.Buffer{}
+	return struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(bytes.Join(data, nil)), written}, written
+}
+
+// nativeServerInit - 4x2 screen with 16-bit pixel format
+func nativeServerInit() ServerInit {
+	return ServerInit{
+		Width:       4,
+		Height:      2,
+		PixelFormat: PixelFormat{BitsPerPixel: 16, Depth: 16, TrueColour: 1, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5},
+		Name:        "test",
+	}
+}
+
+// clientInit - messages sent by client after security handshake
+func clientInit() []byte {
+	return append([]byte{
+		1,
+		SetPixelFormat, 0, 0, 0, 32, 24, 0, 1, 0, 255, 0, 255, 0, 255, 16, 8, 0, 0, 0, 0,
+		SetEncodings, 0, 0, 3},
+		message([]int32{EncodingCopyRect, EncodingRaw, EncodingDesktopSize})...)
+}
+
+func TestHandshakeWithoutAuthentication(t *testing.T) {
+	update := message([]byte{FramebufferUpdate, 0}, uint16(1), rectangle{0, 0, 1, 1, EncodingRaw}, redPixel)
+	rw, written := serverConn([]byte("RFB 003.003\n"), message(uint32(SecurityNone)), nativeServerInit().bytes(), update)
+
+	c, err := Handshake(rw, "")
+	assert.NoError(t, err)
+	assert.Equal(t, c.Width, uint16(4))
+	assert.Equal(t, c.Height, uint16(2))
+	assert.Equal(t, c.Name, "test")
+	assert.Equal(t, c.PixelFormat, RecordingPixelFormat)
+	assert.Equal(t, written.Bytes(), append([]byte("RFB 003.003\n"), clientInit()...))
+
+	written.Reset()
+	assert.NoError(t, c.RequestUpdate(true))
+	assert.Equal(t, written.Bytes(), []byte{FramebufferUpdateRequest, 1, 0, 0, 0, 0, 0, 4, 0, 2})
+	msg, err := c.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, msg, update)
+	_, err = c.ReadMessage()
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestHandshakeWithPassword(t *testing.T) {
+	challenge := bytes.Repeat([]byte{0x5A}, 16)
+	rw, written := serverConn([]byte("RFB 003.008\n"), []byte{2, SecurityVNC, 16}, challenge, message(uint32(0)), nativeServerInit().bytes())
+
+	_, err := Handshake(rw, "selenoid")
+	assert.NoError(t, err)
+
+	key := []byte("selenoid")
+	for i := range key {
+		key[i] = bits.Reverse8(key[i])
+	}
+	cipher, _ := des.NewCipher(key)
+	response := make([]byte, 16)
+	cipher.Encrypt(response, challenge)
+	cipher.Encrypt(response[8:], challenge[8:])
+	expected := bytes.Join([][]byte{[]byte("RFB 003.008\n"), {SecurityVNC}, response, clientInit()}, nil)
+	assert.Equal(t, written.Bytes(), expected)
+}
+
+func TestHandshakePrefersNoAuthentication(t *testing.T) {
+	rw, written := serverConn([]byte("RFB 003.007\n"), []byte{2, SecurityVNC, SecurityNone}, nativeServerInit().bytes())
+
+	_, err := Handshake(rw, "selenoid")
+	assert.NoError(t, err)
+	assert.Equal(t, written.Bytes(), bytes.Join([][]byte{[]byte("RFB 003.007\n"), {SecurityNone}, clientInit()}, nil))
+}
+
+func TestHandshakeErrors(t *testing.T) {
+	handshake := func(data ...[]byte) error {
+		rw, _ := serverConn(data...)
+		_, err := Handshake(rw, "selenoid")
+		return err
+	}
+	assert.EqualError(t, handshake([]byte("RFB 004.000\n")), `unsupported protocol version "RFB 004.000\n"`)
+	assert.EqualError(t, handshake([]byte("HTTP/1.1 200")), `unsupported protocol version "HTTP/1.1 200"`)
+	assert.EqualError(t, handshake([]byte("RFB 003.008\n"), []byte{0}, message(uint32(8)), []byte("too many")), "too many")
+	assert.EqualError(t, handshake([]byte("RFB 003.003\n"), message(uint32(0))), "connection failed")
+	assert.EqualError(t, handshake([]byte("RFB 003.008\n"), []byte{1, 16}), "unsupported security types [16]")
+	assert.EqualError(t, handshake([]byte("RFB 003.003\n"), message(uint32(SecurityVNC)), make([]byte, 16), message(uint32(1))), "authentication failed")
+	assert.EqualError(t, handshake([]byte("RFB 003.008\n"), []byte{1, SecurityVNC}, make([]byte, 16), message(uint32(1), uint32(6)), []byte("denied")), "denied")
+	assert.Error(t, handshake([]byte("RFB 003.008\n"), []byte{1, SecurityNone}, message(uint32(0)), []byte{0, 4}))
+}
+
+func TestReadMessage(t *testing.T) {
+	si := ServerInit{Width: 4, Height: 2, PixelFormat: RecordingPixelFormat}
+	fb := newFramebuffer(si)
+	fb.Damage = image.Rectangle{}
+	data := bytes.Join([][]byte{
+		message([]byte{FramebufferUpdate, 0}, uint16(2),
+			rectangle{0, 0, 2, 2, EncodingRaw}, bytes.Repeat(bluePixel, 4),
+			rectangle{2, 0, 2, 2, EncodingRaw}, bytes.Repeat(redPixel, 4)),
+		{Bell},
+		message([]byte{ServerCutText, 0, 0, 0}, uint32(5), []byte("hello")),
+		message([]byte{SetColourMapEntries, 0}, uint16(0), uint16(1), make([]byte, 6)),
+		message([]byte{FramebufferUpdate, 0}, uint16(2),
+			rectangle{1, 1, 1, 1, EncodingRaw}, greenPixel,
+			rectangle{2, 0, 2, 1, EncodingCopyRect}, uint16(0), uint16(0)),
+		message([]byte{FramebufferUpdate, 0}, uint16(1), rectangle{0, 0, 8, 4, EncodingDesktopSize}),
+	}, nil)
+	r := bytes.NewReader(data)
+
+	assert.NoError(t, readMessage(r, &si, fb))
+	assert.Equal(t, fb.RGBAAt(1, 1), blue)
+	assert.Equal(t, fb.RGBAAt(3, 1), red)
+	assert.Equal(t, fb.Damage, fb.Bounds())
+	fb.Damage = image.Rectangle{}
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, readMessage(r, &si, fb))
+	}
+	assert.True(t, fb.Damage.Empty())
+
+	assert.NoError(t, readMessage(r, &si, fb))
+	assert.Equal(t, fb.RGBAAt(1, 1), green)
+	assert.Equal(t, fb.RGBAAt(2, 0), blue)
+	assert.Equal(t, fb.RGBAAt(3, 1), red)
+	assert.Equal(t, fb.Damage, image.Rect(1, 0, 4, 2))
+
+	assert.NoError(t, readMessage(r, &si, fb))
+	assert.Equal(t, si.Width, uint16(8))
+	assert.Equal(t, si.Height, uint16(4))
+	assert.Equal(t, fb.Bounds(), image.Rect(0, 0, 8, 4))
+	assert.Equal(t, fb.Damage, fb.Bounds())
+	assert.Equal(t, readMessage(r, &si, fb), io.EOF)
+
+	// Messages are skipped without framebuffer
+	assert.NoError(t, readMessage(bytes.NewReader(data[:len(data)-16]), &si, nil))
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	si := ServerInit{Width: 4, Height: 2, PixelFormat: RecordingPixelFormat}
+	read := func(data []byte) error {
+		return readMessage(bytes.NewReader(data), &si, nil)
+	}
+	assert.EqualError(t, read([]byte{150}), "unsupported message type 150")
+	assert.EqualError(t, read(message([]byte{FramebufferUpdate, 0}, uint16(1), rectangle{0, 0, 1, 1, 16})), "unsupported encoding 16")
+	assert.Equal(t, read(message([]byte{FramebufferUpdate, 0}, uint16(1), rectangle{0, 0, 2, 1, EncodingRaw}, redPixel)), io.ErrUnexpectedEOF)
+	assert.Equal(t, read([]byte{ServerCutText, 0}), io.ErrUnexpectedEOF)
+	assert.Equal(t, read(message([]byte{ServerCutText, 0, 0, 0}, uint32(5), []byte("he"))), io.ErrUnexpectedEOF)
+}
+
+func TestFBSRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, ServerInit{Width: 2, Height: 1, PixelFormat: RecordingPixelFormat, Name: "test"})
+	assert.NoError(t, err)
+	// Odd length message checks padding of blocks
+	assert.NoError(t, w.WriteMessage(message([]byte{ServerCutText, 0, 0, 0}, uint32(3), []byte("abc"))))
+	assert.NoError(t, w.WriteMessage(message([]byte{FramebufferUpdate, 0}, uint16(1), rectangle{0, 0, 2, 1, EncodingRaw}, redPixel, greenPixel)))
+	update := message([]byte{FramebufferUpdate, 0}, uint16(1), rectangle{1, 0, 1, 1, EncodingRaw}, bluePixel)
+	// Message split into several blocks is read as one
+	assert.NoError(t, w.WriteMessage(update[:5]))
+	assert.NoError(t, w.WriteMessage(update[5:]))
+	recording := buf.Bytes()
+
+	p, err := NewPlayer(bytes.NewReader(recording))
+	assert.NoError(t, err)
+	assert.Equal(t, p.Name, "test")
+	assert.Equal(t, p.Bounds(), image.Rect(0, 0, 2, 1))
+	p.Damage = image.Rectangle{}
+
+	_, err = p.Next()
+	assert.NoError(t, err)
+	assert.True(t, p.Damage.Empty())
+	_, err = p.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, p.RGBAAt(0, 0), red)
+	assert.Equal(t, p.RGBAAt(1, 0), green)
+	_, err = p.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, p.RGBAAt(1, 0), blue)
+	_, err = p.Next()
+	assert.Equal(t, err, io.EOF)
+
+	p, err = NewPlayer(bytes.NewReader(recording[:len(recording)-2]))
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = p.Next()
+		assert.NoError(t, err)
+	}
+	_, err = p.Next()
+	assert.EqualError(t, err, "truncated recording: unexpected EOF")
+}
+
+func TestPlayerUnsupportedFiles(t *testing.T) {
+	_, err := NewPlayer(bytes.NewReader([]byte("RFB 003.008\n")))
+	assert.EqualError(t, err, "not an FBS 1.0 file")
+
+	var buf bytes.Buffer
+	_, err = NewWriter(&buf, nativeServerInit())
+	assert.NoError(t, err)
+	_, err = NewPlayer(&buf)
+	assert.EqualError(t, err, "unsupported pixel format: 16 bits per pixel, true colour 1")
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -138,3 +139,99 @@ func TestFileMatches(t *testing.T) {
 	assert.NoError(t, err)
 	assert.False(t, matches)
 }
+
+func TestS3UploaderMetadata(t *testing.T) {
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+	}))
+	defer srv.Close()
+
+	uploader := &upload.S3Uploader{
+		Endpoint:             srv.URL,
+		Region:               "us-west-1",
+		AccessKey:            "some-access-key",
+		SecretKey:            "some-secret-key",
+		BucketName:           "test-bucket",
+		KeyPattern:           "$fileName",
+		ForcePathStyle:       true,
+		StorageClass:         "STANDARD_IA",
+		ServerSideEncryption: "aws:kms",
+		SSEKMSKeyId:          "some-key-id",
+		ACL:                  "bucket-owner-full-control",
+		Tagging:              true,
+		KeepFiles:            true,
+	}
+	uploader.Init()
+	f, _ := os.CreateTemp("", "some-file")
+	defer os.Remove(f.Name())
+	input := event.CreatedFile{
+		Event: event.Event{
+			RequestId: 4343,
+			SessionId: "some-session-id",
+			Session: &session.Session{
+				Quota: "some-user",
+				Caps: session.Caps{
+					Name:     "firefox",
+					Version:  "57.0",
+					TestName: "Тест login?",
+					Labels:   map[string]string{"team": "qa", "build": "#42"},
+				},
+			},
+		},
+		Name: f.Name(),
+		Type: "log",
+	}
+	location, uploaded, err := uploader.UploadTo(input)
+	assert.NoError(t, err)
+	assert.True(t, uploaded)
+	assert.Equal(t, location, "s3://test-bucket/"+filepath.Base(f.Name()))
+	link, ok, err := uploader.Link(location, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(link, srv.URL+"/test-bucket/"+filepath.Base(f.Name())+"?"))
+	assert.Contains(t, link, "X-Amz-Expires=3600")
+	_, ok, _ = uploader.Link("s3://another-bucket/some-file", time.Hour)
+	assert.False(t, ok)
+
+	assert.Equal(t, headers.Get("X-Amz-Storage-Class"), "STANDARD_IA")
+	assert.Equal(t, headers.Get("X-Amz-Server-Side-Encryption"), "aws:kms")
+	assert.Equal(t, headers.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"), "some-key-id")
+	assert.Equal(t, headers.Get("X-Amz-Acl"), "bucket-owner-full-control")
+	assert.Equal(t, headers.Get("X-Amz-Meta-Session-Id"), "some-session-id")
+	assert.Equal(t, headers.Get("X-Amz-Meta-Quota"), "some-user")
+	assert.Equal(t, headers.Get("X-Amz-Meta-Label-Team"), "qa")
+	assert.Equal(t, headers.Get("X-Amz-Meta-Test-Name"), "=?utf-8?q?=D0=A2=D0=B5=D1=81=D1=82_login=3F?=")
+	assert.Equal(t, headers.Get("X-Amz-Tagging"), "browser-name=firefox&browser-version=57.0&file-type=log&label-build=_42&label-team=qa&quota=some-user&session-id=some-session-id&test-name=%D0%A2%D0%B5%D1%81%D1%82+login_")
+
+	uploader.Tagging = false
+	_, _, err = uploader.UploadTo(input)
+	assert.NoError(t, err)
+	assert.Empty(t, headers.Get("X-Amz-Tagging"))
+	assert.Equal(t, headers.Get("X-Amz-Meta-Session-Id"), "some-session-id")
+}
+
+func TestS3UploadTarget(t *testing.T) {
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+	}))
+	defer srv.Close()
+	conf, _ := os.CreateTemp("", "upload*.json")
+	defer os.Remove(conf.Name())
+	_, _ = conf.WriteString(`[{"type": "s3", "settings": {
+		"endpoint": "` + srv.URL + `", "region": "us-west-1", "accessKey": "some-access-key", "secretKey": "some-secret-key",
+		"bucketName": "test-bucket", "forcePathStyle": true, "sse": "aws:kms", "sseKmsKeyId": "some-key-id", "keepFiles": true
+	}}]`)
+	_ = conf.Close()
+
+	targets, err := upload.LoadTargets(conf.Name())
+	assert.NoError(t, err)
+	f, _ := os.CreateTemp("", "some-file")
+	defer os.Remove(f.Name())
+	uploaded, err := targets[0].Upload(testCreatedFile(f.Name()))
+	assert.NoError(t, err)
+	assert.True(t, uploaded)
+	assert.Equal(t, headers.Get("X-Amz-Server-Side-Encryption"), "aws:kms")
+	assert.Equal(t, headers.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"), "some-key-id")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+	"github.com/aerokube/selenoid/session"
+)
+
+const sessionCommandTimeout = 10 * time.Second
+
+// sessionCommand - sends WebDriver GET command directly to session, so that session idle timeout is not reset, and returns its string value
+func sessionCommand(sid string, sess *session.Session, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sessionCommandTimeout)
+	defer cancel()
+	u := *sess.URL
+	u.Path = path.Clean(sess.URL.Path + "/session/" + sid + slash + command)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Host = "localhost"
+	if sess.Origin != "" {
+		req.Host = sess.Origin
+	}
+	rsp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", command, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", command, rsp.Status)
+	}
+	var reply struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(rsp.Body).Decode(&reply); err != nil {
+		return "", fmt.Errorf("%s: %v", command, err)
+	}
+	return reply.Value, nil
+}
+
+// takeScreenshot - browser screenshot as PNG
+func takeScreenshot(sid string, sess *session.Session) ([]byte, error) {
+	value, err := sessionCommand(sid, sess, "screenshot")
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("decode screenshot: %v", err)
+	}
+	return data, nil
+}
+
+// saveFinalState - saves screenshot and page source requested in capabilities before session is removed
+func saveFinalState(requestId uint64, id string, sess *session.Session) {
+	if screenshotOutputDir == "" || (!sess.Caps.FinalScreenshot && !sess.Caps.FinalPageSource) {
+		return
+	}
+	sessionId := preprocessSessionId(id)
+	save := func(status string, fileType string, extension string, take func() ([]byte, error)) {
+		data, err := take()
+		if err != nil {
+			log.Printf("[%d] [%s_ERROR] [%s] [%v]", requestId, status, id, err)
+			return
+		}
+		filename := filepath.Join(screenshotOutputDir, sessionId+extension)
+		err = os.WriteFile(filename, data, 0644)
+		if err != nil {
+			log.Printf("[%d] [%s_ERROR] [%s] [Failed to save to %s: %v]", requestId, status, id, filename, err)
+			return
+		}
+		log.Printf("[%d] [%s] [%s] [%s]", requestId, status, id, filename)
+		event.FileCreated(event.CreatedFile{
+			Event: event.Event{
+				RequestId: requestId,
+				SessionId: sessionId,
+				Session:   sess.Snapshot(),
+			},
+			Name: filename,
+			Type: fileType,
+		})
+	}
+	if sess.Caps.FinalScreenshot {
+		save("FINAL_SCREENSHOT", screenshotFileType, screenshotFileExtension, func() ([]byte, error) {
+			return takeScreenshot(id, sess)
+		})
+	}
+	if sess.Caps.FinalPageSource {
+		save("FINAL_PAGE_SOURCE", pageSourceFileType, pageSourceFileExtension, func() ([]byte, error) {
+			source, err := sessionCommand(id, sess, "source")
+			return []byte(source), err
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestFinalScreenshotAndPageSource(t *testing.T) {
+	dir, err := os.MkdirTemp("", "selenoid-screenshots")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	screenshotOutputDir = dir
+	defer func() {
+		screenshotOutputDir = ""
+	}()
+	manager = &HTTPTest{Handler: Selenium()}
+
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"desiredCapabilities":{"finalScreenshot":true,"finalPageSource":true}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var sess map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sess))
+	sid := sess["sessionId"]
+	fileId := preprocessSessionId(sid)
+
+	req, _ := http.NewRequest(http.MethodDelete, With(srv.URL).Path("/wd/hub/session/"+sid), nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+
+	f, err := os.Open(filepath.Join(dir, fileId+screenshotFileExtension))
+	assert.NoError(t, err)
+	defer f.Close()
+	_, err = png.Decode(f)
+	assert.NoError(t, err)
+	source, err := os.ReadFile(filepath.Join(dir, fileId+pageSourceFileExtension))
+	assert.NoError(t, err)
+	assert.Equal(t, string(source), "<html></html>")
+
+	waitFor(t, func() bool {
+		as, ok := artifacts.get(fileId+screenshotFileExtension, fileId)
+		return ok && as.SessionId == fileId
+	})
+	var types []string
+	for _, f := range sessionFiles(fileId) {
+		types = append(types, f.Type)
+	}
+	assert.Subset(t, types, []string{screenshotFileType, pageSourceFileType})
+}
+
+func TestNoFinalScreenshotByDefault(t *testing.T) {
+	dir, err := os.MkdirTemp("", "selenoid-screenshots")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	screenshotOutputDir = dir
+	defer func() {
+		screenshotOutputDir = ""
+	}()
+	manager = &HTTPTest{Handler: Selenium()}
+
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte("{}")))
+	assert.NoError(t, err)
+	var sess map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sess))
+
+	req, _ := http.NewRequest(http.MethodDelete, With(srv.URL).Path("/wd/hub/session/"+sess["sessionId"]), nil)
+	_, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
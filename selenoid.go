@@ -43,8 +43,9 @@ var (
 			return http.ErrUseLastResponse
 		},
 	}
-	num     uint64
-	numLock sync.RWMutex
+	num      uint64
+	numLock  sync.RWMutex
+	stopping sync.WaitGroup
 )
 
 type request struct {
@@ -146,23 +147,34 @@ func create(w http.ResponseWriter, r *http.Request) {
 	var ok bool
 	var sessionTimeout time.Duration
 	var finalVideoName, finalLogName string
-	for _, fmc := range firstMatchCaps {
-		caps = browser.Caps
-		_ = mergo.Merge(&caps, *fmc)
-		caps.ProcessExtensionCapabilities()
-		sessionTimeout, err = getSessionTimeout(caps.SessionTimeout, maxTimeout, timeout)
+	var saveLog bool
+	var warnings []string
+	// prepare - applies policies and computes session settings, error is sent to client when capabilities are not valid
+	prepare := func(caps *session.Caps) bool {
+		policyMaxTimeout, err := policies.Apply(user, caps)
+		if err != nil {
+			log.Printf("[%d] [POLICY_VIOLATION] [%s] [%s] [%v]", requestId, user, remote, err)
+			jsonerror.InvalidArgument(err).Encode(w)
+			queue.Drop()
+			return false
+		}
+		sessionMaxTimeout := maxTimeout
+		if policyMaxTimeout > 0 {
+			sessionMaxTimeout = min(sessionMaxTimeout, policyMaxTimeout)
+		}
+		sessionTimeout, err = getSessionTimeout(caps.SessionTimeout, sessionMaxTimeout, min(timeout, sessionMaxTimeout))
 		if err != nil {
 			log.Printf("[%d] [BAD_SESSION_TIMEOUT] [%s]", requestId, caps.SessionTimeout)
 			jsonerror.InvalidArgument(err).Encode(w)
 			queue.Drop()
-			return
+			return false
 		}
 		resolution, err := getScreenResolution(caps.ScreenResolution)
 		if err != nil {
 			log.Printf("[%d] [BAD_SCREEN_RESOLUTION] [%s]", requestId, caps.ScreenResolution)
 			jsonerror.InvalidArgument(err).Encode(w)
 			queue.Drop()
-			return
+			return false
 		}
 		caps.ScreenResolution = resolution
 		videoScreenSize, err := getVideoScreenSize(caps.VideoScreenSize, resolution)
@@ -170,22 +182,62 @@ func create(w http.ResponseWriter, r *http.Request) {
 			log.Printf("[%d] [BAD_VIDEO_SCREEN_SIZE] [%s]", requestId, caps.VideoScreenSize)
 			jsonerror.InvalidArgument(err).Encode(w)
 			queue.Drop()
-			return
+			return false
 		}
 		caps.VideoScreenSize = videoScreenSize
+		saveLog = logOutputDir != "" && (saveAllLogs || caps.Log)
+		warnings, err = guardDiskSpace(caps, &saveLog)
+		if err != nil {
+			log.Printf("[%d] [LOW_DISK_SPACE] [%s] [%s] [%v]", requestId, user, remote, err)
+			jsonerror.SessionNotCreated(err).Encode(w)
+			queue.Drop()
+			return false
+		}
+		for _, warning := range warnings {
+			log.Printf("[%d] [LOW_DISK_SPACE] [%s] [%s] [%s]", requestId, user, remote, warning)
+		}
+		if caps.VNCRecording {
+			caps.VNC = true
+		}
 		finalVideoName = caps.VideoName
 		if caps.Video && !disableDocker {
 			caps.VideoName = getTemporaryFileName(videoOutputDir, videoFileExtension)
 		}
 		finalLogName = caps.LogName
-		if logOutputDir != "" && (saveAllLogs || caps.Log) {
+		if saveLog {
 			caps.LogName = getTemporaryFileName(logOutputDir, logFileExtension)
 		}
+		return true
+	}
+	var requested session.Caps
+	for _, fmc := range firstMatchCaps {
+		requested = browser.Caps
+		_ = mergo.Merge(&requested, *fmc)
+		requested.ProcessExtensionCapabilities()
+		caps = requested
+		if !prepare(&caps) {
+			return
+		}
 		starter, ok = manager.Find(caps, requestId)
 		if ok {
 			break
 		}
 	}
+	if ok && admissionHook.Enabled() {
+		// Hook is called once for chosen alternative, patched capabilities have to satisfy policies too
+		caps = requested
+		err = admissionHook.Review(r.Context(), requestId, user, remote, &caps)
+		if err != nil {
+			log.Printf("[%d] [ADMISSION_REJECTED] [%s] [%s] [%v]", requestId, user, remote, err)
+			jsonerror.SessionNotCreated(err).Encode(w)
+			queue.Drop()
+			return
+		}
+		if !prepare(&caps) {
+			return
+		}
+		starter, ok = manager.Find(caps, requestId)
+	}
 	if !ok {
 		log.Printf("[%d] [ENVIRONMENT_NOT_AVAILABLE] [%s] [%s]", requestId, caps.BrowserName(), caps.Version)
 		jsonerror.InvalidArgument(errors.New("Requested environment is not available")).Encode(w)
@@ -201,6 +253,10 @@ func create(w http.ResponseWriter, r *http.Request) {
 	}
 	u := startedService.Url
 	cancel := startedService.Cancel
+	videoStarted := startedService.VideoStarted
+	if videoStarted.IsZero() {
+		videoStarted = time.Now()
+	}
 	host := "localhost"
 	if startedService.Origin != "" {
 		host = startedService.Origin
@@ -267,6 +323,7 @@ func create(w http.ResponseWriter, r *http.Request) {
 		}
 		ID string `json:"sessionId"`
 	}
+	var webSocketUrl string
 	location := resp.Header.Get("Location")
 	if location != "" {
 		l, err := url.Parse(location)
@@ -290,7 +347,8 @@ func create(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(resp.StatusCode)
 			return
 		}
-		newBody, sessionId, err := processBody(body, r.Host)
+		var newBody []byte
+		newBody, s.ID, webSocketUrl, err = processBody(body, webSocketScheme(r), r.Host, warnings)
 		if err != nil {
 			log.Printf("[%d] [ERROR_PROCESSING_RESPONSE] [%v]", requestId, err)
 			queue.Drop()
@@ -302,7 +360,6 @@ func create(w http.ResponseWriter, r *http.Request) {
 		resp.ContentLength = int64(len(newBody))
 		w.WriteHeader(resp.StatusCode)
 		_, _ = w.Write(newBody)
-		s.ID = sessionId
 	}
 	if s.ID == "" {
 		log.Printf("[%d] [SESSION_FAILED] [%s] [%s]", requestId, u.String(), resp.Status)
@@ -322,41 +379,57 @@ func create(w http.ResponseWriter, r *http.Request) {
 			request{r}.session(s.ID).Delete(requestId)
 		}),
 		Started: time.Now()}
+	if webSocketUrl != "" {
+		sess.BiDi, err = bidiBackend(webSocketUrl, u, startedService.Origin)
+		if err != nil {
+			log.Printf("[%d] [BIDI_ERROR] [%s] [%v]", requestId, s.ID, err)
+		}
+	}
 	cancelAndRenameFiles := func() {
+		// Recording is stopped while VNC server is still running not to lose last frames
+		stopVNCRecording(s.ID)
 		cancel()
 		sessionId := preprocessSessionId(s.ID)
+		// Listeners get final file names and never see the running session
+		stopped := sess.Snapshot()
+		if caps.Video && !disableDocker {
+			if finalVideoName == "" {
+				finalVideoName = sessionId + videoFileExtension
+			}
+			stopped.Caps.VideoName = finalVideoName
+		}
+		if saveLog {
+			if finalLogName == "" {
+				finalLogName = sessionId + logFileExtension
+			}
+			stopped.Caps.LogName = finalLogName
+		}
 		e := event.Event{
 			RequestId: requestId,
 			SessionId: sessionId,
-			Session:   sess,
+			Session:   stopped,
 		}
 		if caps.Video && !disableDocker {
 			oldVideoName := filepath.Join(videoOutputDir, caps.VideoName)
-			if finalVideoName == "" {
-				finalVideoName = sessionId + videoFileExtension
-				e.Session.Caps.VideoName = finalVideoName
-			}
 			newVideoName := filepath.Join(videoOutputDir, finalVideoName)
 			err := os.Rename(oldVideoName, newVideoName)
 			if err != nil {
 				log.Printf("[%d] [VIDEO_ERROR] [%s]", requestId, fmt.Sprintf("Failed to rename %s to %s: %v", oldVideoName, newVideoName, err))
+				saveSubtitles(e, s.ID, "")
 			} else {
 				createdFile := event.CreatedFile{
 					Event: e,
 					Name:  newVideoName,
-					Type:  "video",
+					Type:  videoFileType,
 				}
 				event.FileCreated(createdFile)
+				saveSubtitles(e, s.ID, newVideoName)
 			}
 		}
-		if logOutputDir != "" && (saveAllLogs || caps.Log) {
+		if saveLog {
 			//The following logic will fail if -capture-driver-logs is enabled and a session is requested in driver mode.
 			//Specifying both -log-output-dir and -capture-driver-logs in that case is considered a misconfiguration.
 			oldLogName := filepath.Join(logOutputDir, caps.LogName)
-			if finalLogName == "" {
-				finalLogName = sessionId + logFileExtension
-				e.Session.Caps.LogName = finalLogName
-			}
 			newLogName := filepath.Join(logOutputDir, finalLogName)
 			err := os.Rename(oldLogName, newLogName)
 			if err != nil {
@@ -365,14 +438,52 @@ func create(w http.ResponseWriter, r *http.Request) {
 				createdFile := event.CreatedFile{
 					Event: e,
 					Name:  newLogName,
-					Type:  "log",
+					Type:  logFileType,
 				}
 				event.FileCreated(createdFile)
 			}
 		}
+		saveCommandLog(e, s.ID)
+		saveHAR(e, s.ID)
+		saveConsoleLog(e, s.ID)
+		saveVNCRecording(e, s.ID)
 		event.SessionStopped(event.StoppedSession{e})
 	}
 	sess.Cancel = cancelAndRenameFiles
+	if caps.CommandLog && logOutputDir != "" {
+		err := startCommandLog(s.ID)
+		if err != nil {
+			log.Printf("[%d] [COMMAND_LOG_ERROR] [%v]", requestId, err)
+		}
+	}
+	if caps.Video && !disableDocker && !disableVideoSubtitles {
+		startSubtitles(s.ID, videoStarted)
+	}
+	if caps.HAR && logOutputDir != "" {
+		if sess.HostPort.Devtools != "" {
+			startHAR(requestId, s.ID, sess.HostPort.Devtools)
+		} else {
+			log.Printf("[%d] [HAR_ERROR] [%s] [Browser has no DevTools endpoint]", requestId, s.ID)
+		}
+	}
+	if caps.ConsoleLog && logOutputDir != "" {
+		err := errors.New("browser has no DevTools endpoint")
+		if sess.HostPort.Devtools != "" {
+			err = startConsoleLog(requestId, s.ID, sess.HostPort.Devtools)
+		}
+		if err != nil {
+			log.Printf("[%d] [CONSOLE_LOG_ERROR] [%s] [%v]", requestId, s.ID, err)
+		}
+	}
+	if caps.VNCRecording {
+		err := errors.New("browser has no VNC endpoint")
+		if sess.HostPort.VNC != "" {
+			err = startVNCRecording(requestId, s.ID, sess.HostPort.VNC, caps.VideoFrameRate)
+		}
+		if err != nil {
+			log.Printf("[%d] [VNC_RECORDING_ERROR] [%s] [%v]", requestId, s.ID, err)
+		}
+	}
 	sessions.Put(s.ID, sess)
 	queue.Create()
 	log.Printf("[%d] [SESSION_CREATED] [%s] [%d] [%.2fs]", requestId, s.ID, i, info.SecondsSince(sessionStartTime))
@@ -409,12 +520,13 @@ func removeSelenoidOptions(input []byte) []byte {
 	return ret
 }
 
-func processBody(input []byte, host string) ([]byte, string, error) {
+// processBody - adds Selenoid capabilities to new session response, returns session id and BiDi URL returned by driver
+func processBody(input []byte, scheme string, host string, warnings []string) ([]byte, string, string, error) {
 	body := make(map[string]interface{})
-	sessionId := ""
+	sessionId, webSocketUrl := "", ""
 	err := json.Unmarshal(input, &body)
 	if err != nil {
-		return nil, sessionId, fmt.Errorf("parse body response: %v", err)
+		return nil, sessionId, webSocketUrl, fmt.Errorf("parse body response: %v", err)
 	}
 	// handle jsonwp response from older browsers (chrome < 75)
 	if rawId, ok := body["sessionId"]; ok {
@@ -427,12 +539,19 @@ func processBody(input []byte, host string) ([]byte, string, error) {
 				if raw, ok := v["capabilities"]; ok {
 					if c, ok := raw.(map[string]interface{}); ok {
 						sessionId = v["sessionId"].(string)
-						c["se:cdp"] = fmt.Sprintf("ws://%s/devtools/%s/", host, sessionId)
+						c["se:cdp"] = fmt.Sprintf("%s://%s/devtools/%s/", scheme, host, sessionId)
 						if rbv, ok := c["browserVersion"]; ok {
 							if bv, ok := rbv.(string); ok {
 								c["se:cdpVersion"] = bv
 							}
 						}
+						if ws, ok := c["webSocketUrl"].(string); ok && ws != "" {
+							webSocketUrl = ws
+							c["webSocketUrl"] = fmt.Sprintf("%s://%s/bidi/%s", scheme, host, sessionId)
+						}
+						if len(warnings) > 0 {
+							c["selenoid:warnings"] = warnings
+						}
 					}
 				}
 			}
@@ -440,9 +559,9 @@ func processBody(input []byte, host string) ([]byte, string, error) {
 	}
 	ret, err := json.Marshal(body)
 	if err != nil {
-		return nil, sessionId, fmt.Errorf("marshal response: %v", err)
+		return nil, sessionId, webSocketUrl, fmt.Errorf("marshal response: %v", err)
 	}
-	return ret, sessionId, nil
+	return ret, sessionId, webSocketUrl, nil
 }
 
 func preprocessSessionId(sid string) string {
@@ -453,8 +572,26 @@ func preprocessSessionId(sid string) string {
 }
 
 const (
-	videoFileExtension = ".mp4"
-	logFileExtension   = ".log"
+	videoFileExtension        = ".mp4"
+	logFileExtension          = ".log"
+	metadataFileExtension     = ".json"
+	screenshotFileExtension   = ".png"
+	pageSourceFileExtension   = ".html"
+	commandLogFileExtension   = ".commands.jsonl"
+	subtitlesFileExtension    = ".vtt"
+	harFileExtension          = ".har"
+	consoleLogFileExtension   = ".console.jsonl"
+	vncRecordingFileExtension = ".fbs.gz"
+	videoFileType             = "video"
+	logFileType               = "log"
+	metadataFileType          = "metadata"
+	screenshotFileType        = "screenshot"
+	pageSourceFileType        = "source"
+	commandLogFileType        = "commands"
+	subtitlesFileType         = "subtitles"
+	harFileType               = "har"
+	consoleLogFileType        = "console"
+	vncRecordingFileType      = "recording"
 )
 
 var (
@@ -539,7 +676,12 @@ func proxy(w http.ResponseWriter, r *http.Request) {
 		done <- cancel
 	}()
 	requestId := serial()
-	(&httputil.ReverseProxy{
+	sid := strings.Split(r.URL.Path, slash)[2]
+	if sess, ok := sessions.Get(sid); ok && r.Method == http.MethodDelete && len(strings.Split(r.URL.Path, slash)) == 3 {
+		// Browser is asked for final state before session lock is taken not to block other requests
+		saveFinalState(requestId, sid, sess)
+	}
+	recordCommand(sid, w, r, (&httputil.ReverseProxy{
 		Director: func(r *http.Request) {
 			fragments := strings.Split(r.URL.Path, slash)
 			id := fragments[2]
@@ -562,14 +704,10 @@ func proxy(w http.ResponseWriter, r *http.Request) {
 					close(sess.TimeoutCh)
 				}
 				if r.Method == http.MethodDelete && len(fragments) == 3 {
-					if enableFileUpload {
-						_ = os.RemoveAll(filepath.Join(os.TempDir(), id))
-					}
-					cancel = sess.Cancel
-					sessions.Remove(id)
-					queue.Release()
+					cancel = removeSession(requestId, id, sess)
 					log.Printf("[%d] [SESSION_DELETED] [%s]", requestId, id)
 				} else {
+					sess.Touch()
 					sess.TimeoutCh = onTimeout(sess.Timeout, func() {
 						request{r}.session(id).Delete(requestId)
 					})
@@ -593,7 +731,26 @@ func proxy(w http.ResponseWriter, r *http.Request) {
 			r.URL.Path = paths.Error
 		},
 		ErrorHandler: defaultErrorHandler(requestId),
-	}).ServeHTTP(w, r)
+	}).ServeHTTP)
+}
+
+// removeSession should be called with session lock held, returned function stops the session
+func removeSession(requestId uint64, id string, sess *session.Session) func() {
+	select {
+	case <-sess.TimeoutCh:
+	default:
+		close(sess.TimeoutCh)
+	}
+	if enableFileUpload {
+		_ = os.RemoveAll(filepath.Join(os.TempDir(), id))
+	}
+	sessions.Remove(id)
+	queue.Release()
+	stopping.Add(1)
+	return func() {
+		defer stopping.Done()
+		sess.Cancel()
+	}
 }
 
 func defaultErrorHandler(requestId uint64) func(http.ResponseWriter, *http.Request, error) {
@@ -604,20 +761,13 @@ func defaultErrorHandler(requestId uint64) func(http.ResponseWriter, *http.Reque
 	}
 }
 
-func reverseProxy(hostFn func(sess *session.Session) string, status string) func(http.ResponseWriter, *http.Request) {
+func reverseProxy(hostFn func(sess *session.Session) string, status string, modifyResponse func(*http.Response) error) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		requestId := serial()
 		sid, remainingPath := splitRequestPath(r.URL.Path)
 		sess, ok := sessions.Get(sid)
 		if ok {
-			select {
-			case <-sess.TimeoutCh:
-			default:
-				close(sess.TimeoutCh)
-			}
-			sess.TimeoutCh = onTimeout(sess.Timeout, func() {
-				request{r}.session(sid).Delete(requestId)
-			})
+			resetTimeout(requestId, r, sid, sess)
 			(&httputil.ReverseProxy{
 				Director: func(r *http.Request) {
 					r.URL.Scheme = "http"
@@ -625,7 +775,8 @@ func reverseProxy(hostFn func(sess *session.Session) string, status string) func
 					r.URL.Path = remainingPath
 					log.Printf("[%d] [%s] [%s] [%s]", requestId, status, sid, remainingPath)
 				},
-				ErrorHandler: defaultErrorHandler(requestId),
+				ModifyResponse: modifyResponse,
+				ErrorHandler:   defaultErrorHandler(requestId),
 			}).ServeHTTP(w, r)
 		} else {
 			jsonerror.InvalidSessionID(fmt.Errorf("unknown session %s", sid)).Encode(w)
@@ -639,6 +790,19 @@ func splitRequestPath(p string) (string, string) {
 	return fragments[2], slash + strings.Join(fragments[3:], slash)
 }
 
+// webSocketScheme - scheme of WebSocket URLs pointing to Selenoid, TLS terminating proxy is expected to set X-Forwarded-Proto header
+func webSocketScheme(r *http.Request) string {
+	switch strings.ToLower(r.Header.Get("X-Forwarded-Proto")) {
+	case "https", "wss":
+		return "wss"
+	case "":
+		if r.TLS != nil {
+			return "wss"
+		}
+	}
+	return "ws"
+}
+
 func fileUpload(w http.ResponseWriter, r *http.Request) {
 	var jsonRequest struct {
 		File []byte `json:"file"`
@@ -714,7 +878,15 @@ func vnc(wsconn *websocket.Conn) {
 				_ = wsconn.Close()
 				log.Printf("[%d] [VNC_SESSION_CLOSED] [%s]", requestId, sid)
 			}()
-			_, _ = io.Copy(conn, wsconn)
+			if _, viewOnly := wsconn.Request().URL.Query()[viewOnlyParam]; viewOnly || sess.Caps.VNCViewOnly {
+				log.Printf("[%d] [VNC_VIEW_ONLY] [%s]", requestId, sid)
+				err := copyViewOnly(conn, wsconn)
+				if err != nil && err != io.EOF {
+					log.Printf("[%d] [VNC_ERROR] [%v]", requestId, err)
+				}
+			} else {
+				_, _ = io.Copy(conn, wsconn)
+			}
 			log.Printf("[%d] [VNC_CLIENT_DISCONNECTED] [%s]", requestId, sid)
 		} else {
 			log.Printf("[%d] [VNC_NOT_ENABLED] [%s]", requestId, sid)
@@ -725,7 +897,8 @@ func vnc(wsconn *websocket.Conn) {
 }
 
 const (
-	jsonParam = "json"
+	jsonParam      = "json"
+	locationsParam = "locations"
 )
 
 func logs(w http.ResponseWriter, r *http.Request) {
@@ -738,7 +911,10 @@ func logs(w http.ResponseWriter, r *http.Request) {
 		}
 		user, remote := info.RequestInfo(r)
 		if _, ok := r.URL.Query()[jsonParam]; ok {
-			listFilesAsJson(requestId, w, logOutputDir, "LOG_ERROR")
+			listFilesAsJson(requestId, w, r, logOutputDir, "LOG_ERROR", logFileType)
+			return
+		}
+		if serveUploadedFile(requestId, w, r, logOutputDir, paths.Logs, "UPLOADED_LOG_FILE", logFileType) {
 			return
 		}
 		log.Printf("[%d] [LOG_LISTING] [%s] [%s]", requestId, user, remote)
@@ -749,19 +925,14 @@ func logs(w http.ResponseWriter, r *http.Request) {
 	websocket.Handler(streamLogs).ServeHTTP(w, r)
 }
 
-func listFilesAsJson(requestId uint64, w http.ResponseWriter, dir string, errStatus string) {
-	files, err := os.ReadDir(dir)
+func listFilesAsJson(requestId uint64, w http.ResponseWriter, r *http.Request, dir string, errStatus string, fileType string) {
+	files, err := listFiles(dir, fileType)
 	if err != nil {
-		log.Printf("[%d] [%s] [%s]", requestId, errStatus, fmt.Sprintf("Failed to list directory %s: %v", logOutputDir, err))
+		log.Printf("[%d] [%s] [%s]", requestId, errStatus, fmt.Sprintf("Failed to list directory %s: %v", dir, err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	var ret []string
-	for _, f := range files {
-		ret = append(ret, f.Name())
-	}
-	w.Header().Add("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(ret)
+	writeFileList(w, r, files)
 }
 
 func streamLogs(wsconn *websocket.Conn) {
@@ -791,7 +962,7 @@ func streamLogs(wsconn *websocket.Conn) {
 
 func status(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	ready := limit > sessions.Len()
+	ready := limit > sessions.Len() && !queue.Draining()
 	_ = json.NewEncoder(w).Encode(
 		map[string]interface{}{
 			"value": map[string]interface{}{
@@ -33,9 +33,11 @@ var (
 
 func init() {
 	enableFileUpload = true
+	adminToken = testAdminToken
 	videoOutputDir, _ = os.MkdirTemp("", "selenoid-test")
 	logOutputDir, _ = os.MkdirTemp("", "selenoid-test")
 	saveAllLogs = true
+	previewFPS = 10
 	gitRevision = "test-revision"
 	ggrHost = &ggr.Host{
 		Name: "some-host.example.com",
@@ -784,6 +786,17 @@ func TestServeAndDeleteVideoFile(t *testing.T) {
 	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&files))
 	assert.Equal(t, files, []string{"testfile"})
 
+	rsp, err = http.Get(With(srv.URL).Path("/video/?json&locations"))
+	assert.NoError(t, err)
+	var listed []listedFile
+	assert.NoError(t, json.NewDecoder(rsp.Body).Decode(&listed))
+	assert.Len(t, listed, 1)
+	assert.Equal(t, listed[0].Name, "testfile")
+	assert.True(t, listed[0].Local)
+	assert.Equal(t, listed[0].Size, int64(len("test-data")))
+	assert.NotNil(t, listed[0].Modified)
+	assert.Equal(t, rsp.Header.Get(totalHeader), "1")
+
 	deleteReq, _ := http.NewRequest(http.MethodDelete, With(srv.URL).Path("/video/testfile"), nil)
 	rsp, err = http.DefaultClient.Do(deleteReq)
 	assert.NoError(t, err)
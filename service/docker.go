@@ -201,11 +201,13 @@ func (d *Docker) StartWithCancel() (*StartedService, error) {
 	hostPort := getHostPort(d.Environment, servicePort, d.Caps, stat, pc)
 	u := &url.URL{Scheme: "http", Host: hostPort.Selenium, Path: d.Service.Path}
 
+	var videoStarted time.Time
 	if d.Video {
 		videoContainerId, err = startVideoContainer(ctx, cl, requestId, stat, d.Environment, d.ServiceBase, d.Caps)
 		if err != nil {
 			return nil, fmt.Errorf("start video container: %v", err)
 		}
+		videoStarted = time.Now()
 	}
 
 	serviceStartTime := time.Now()
@@ -237,8 +239,9 @@ func (d *Docker) StartWithCancel() (*StartedService, error) {
 			IPAddress: getContainerIP(d.Environment.Network, stat),
 			Ports:     publishedPortsInfo,
 		},
-		HostPort: hostPort,
-		Origin:   origin,
+		HostPort:     hostPort,
+		Origin:       origin,
+		VideoStarted: videoStarted,
 		Cancel: func() {
 			if videoContainerId != "" {
 				stopVideoContainer(ctx, cl, requestId, videoContainerId, d.Environment)
@@ -47,6 +47,8 @@ type StartedService struct {
 	HostPort  session.HostPort
 	Origin    string
 	Cancel    func()
+	// VideoStarted - when video recorder was started, zero when video is not recorded
+	VideoStarted time.Time
 }
 
 // Starter - interface to create session with cancellation ability
@@ -1,8 +1,10 @@
 package session
 
 import (
+	"encoding/json"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/imdario/mergo"
@@ -20,6 +22,8 @@ type Caps struct {
 	ScreenResolution      string            `json:"screenResolution,omitempty"`
 	Skin                  string            `json:"skin,omitempty"`
 	VNC                   bool              `json:"enableVNC,omitempty"`
+	VNCViewOnly           bool              `json:"vncViewOnly,omitempty"`
+	VNCRecording          bool              `json:"enableVNCRecording,omitempty"`
 	Video                 bool              `json:"enableVideo,omitempty"`
 	Log                   bool              `json:"enableLog,omitempty"`
 	VideoName             string            `json:"videoName,omitempty"`
@@ -27,6 +31,11 @@ type Caps struct {
 	VideoFrameRate        uint16            `json:"videoFrameRate,omitempty"`
 	VideoCodec            string            `json:"videoCodec,omitempty"`
 	LogName               string            `json:"logName,omitempty"`
+	FinalScreenshot       bool              `json:"finalScreenshot,omitempty"`
+	FinalPageSource       bool              `json:"finalPageSource,omitempty"`
+	CommandLog            bool              `json:"enableCommandLog,omitempty"`
+	HAR                   bool              `json:"enableHAR,omitempty"`
+	ConsoleLog            bool              `json:"enableConsoleLog,omitempty"`
 	TestName              string            `json:"name,omitempty"`
 	TimeZone              string            `json:"timeZone,omitempty"`
 	ContainerHostname     string            `json:"containerHostname,omitempty"`
@@ -57,6 +66,35 @@ func (c *Caps) ProcessExtensionCapabilities() {
 	}
 }
 
+// Patch - overrides capabilities with values of keys present in JSON object, unlike merging this applies false, empty and null values too
+func (c *Caps) Patch(patch json.RawMessage) error {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &values); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for k, v := range values {
+		fields[k] = v
+	}
+	data, err = json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	var patched Caps
+	if err := json.Unmarshal(data, &patched); err != nil {
+		return err
+	}
+	*c = patched
+	return nil
+}
+
 func (c *Caps) BrowserName() string {
 	browserName := c.Name
 	if browserName != "" {
@@ -80,6 +118,7 @@ type Session struct {
 	Quota     string
 	Caps      Caps
 	URL       *url.URL
+	BiDi      *url.URL
 	Container *Container
 	HostPort  HostPort
 	Origin    string
@@ -88,15 +127,46 @@ type Session struct {
 	TimeoutCh chan struct{}
 	Started   time.Time
 	Lock      sync.Mutex
+
+	lastActivity atomic.Int64
+}
+
+// Snapshot - copy of session info safe to pass to event listeners while session is changed or stopped
+func (s *Session) Snapshot() *Session {
+	return &Session{
+		Quota:     s.Quota,
+		Caps:      s.Caps,
+		URL:       s.URL,
+		BiDi:      s.BiDi,
+		Container: s.Container,
+		HostPort:  s.HostPort,
+		Origin:    s.Origin,
+		Timeout:   s.Timeout,
+		Started:   s.Started,
+	}
+}
+
+// Touch - remember last session activity time
+func (s *Session) Touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// Idle - time since last session activity
+func (s *Session) Idle() time.Duration {
+	last := s.lastActivity.Load()
+	if last == 0 {
+		return time.Since(s.Started)
+	}
+	return time.Since(time.Unix(0, last))
 }
 
 // HostPort - hold host-port values for all forwarded ports
 type HostPort struct {
-	Selenium   string
-	Fileserver string
-	Clipboard  string
-	VNC        string
-	Devtools   string
+	Selenium   string `json:"selenium,omitempty"`
+	Fileserver string `json:"fileserver,omitempty"`
+	Clipboard  string `json:"clipboard,omitempty"`
+	VNC        string `json:"vnc,omitempty"`
+	Devtools   string `json:"devtools,omitempty"`
 }
 
 // Map - session uuid to sessions mapping
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+	"github.com/aerokube/selenoid/session"
+	assert "github.com/stretchr/testify/require"
+)
+
+type slowListener struct {
+	processed atomic.Int32
+}
+
+func (l *slowListener) OnFileCreated(createdFile event.CreatedFile) {
+	if createdFile.Type != "slow" {
+		return
+	}
+	<-time.After(50 * time.Millisecond)
+	l.processed.Add(1)
+}
+
+func TestWaitForFiles(t *testing.T) {
+	l := &slowListener{}
+	event.AddFileCreatedListener(l)
+	event.FileCreated(event.CreatedFile{
+		Event: event.Event{SessionId: "some-session", Session: &session.Session{}},
+		Name:  "some-file",
+		Type:  "slow",
+	})
+	waitForFiles()
+	assert.Equal(t, l.processed.Load(), int32(1))
+}
+
+func TestWaitForSessionsExpired(t *testing.T) {
+	sessions.Put("shutdown-session", &session.Session{})
+	defer sessions.Remove("shutdown-session")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s := time.Now()
+	waitForSessions(ctx)
+	assert.True(t, time.Since(s) < time.Second)
+}
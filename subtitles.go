@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+)
+
+const (
+	minCueDuration = time.Second
+	maxCueDuration = 5 * time.Second
+)
+
+var (
+	subtitlesLock sync.Mutex
+	subtitles     = make(map[string]*subtitleTrack)
+	// cueText - cue text is one line without markup, escaping > also covers --> separator
+	cueText = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ", "&", "&amp;", "<", "&lt;", ">", "&gt;")
+)
+
+func init() {
+	_ = mime.AddExtensionType(subtitlesFileExtension, "text/vtt")
+}
+
+type cue struct {
+	start, end time.Duration
+	text       string
+}
+
+// subtitleTrack - names of commands received by session relative to video recording start
+type subtitleTrack struct {
+	lock    sync.Mutex
+	started time.Time
+	cues    []cue
+}
+
+func startSubtitles(sid string, videoStarted time.Time) {
+	subtitlesLock.Lock()
+	defer subtitlesLock.Unlock()
+	subtitles[sid] = &subtitleTrack{started: videoStarted}
+}
+
+func getSubtitles(sid string) (*subtitleTrack, bool) {
+	subtitlesLock.Lock()
+	defer subtitlesLock.Unlock()
+	st, ok := subtitles[sid]
+	return st, ok
+}
+
+func (st *subtitleTrack) add(start time.Time, duration time.Duration, text string) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	offset := max(start.Sub(st.started), 0)
+	st.cues = append(st.cues, cue{start: offset, end: offset + duration, text: text})
+}
+
+// write - saves cues in WebVTT format, every cue is shown until the next command but not longer than few seconds
+func (st *subtitleTrack) write(name string) error {
+	st.lock.Lock()
+	cues := append([]cue{}, st.cues...)
+	st.lock.Unlock()
+	sort.SliceStable(cues, func(i, j int) bool {
+		return cues[i].start < cues[j].start
+	})
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	_, _ = fmt.Fprint(w, "WEBVTT\n\n")
+	for i, c := range cues {
+		end := max(c.end, c.start+minCueDuration)
+		if i+1 < len(cues) {
+			end = max(c.end, min(cues[i+1].start, c.start+maxCueDuration))
+		}
+		_, _ = fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, vttTimestamp(c.start), vttTimestamp(end), cueText.Replace(c.text))
+	}
+	err = w.Flush()
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// saveSubtitles - saves subtitles next to video of finished session, subtitles are dropped when video name is empty
+func saveSubtitles(e event.Event, sid string, videoName string) {
+	subtitlesLock.Lock()
+	st, ok := subtitles[sid]
+	delete(subtitles, sid)
+	subtitlesLock.Unlock()
+	if !ok || videoName == "" {
+		return
+	}
+	name := strings.TrimSuffix(videoName, filepath.Ext(videoName)) + subtitlesFileExtension
+	err := st.write(name)
+	if err != nil {
+		log.Printf("[%d] [SUBTITLES_ERROR] [%s]", e.RequestId, fmt.Sprintf("Failed to save %s: %v", name, err))
+		return
+	}
+	event.FileCreated(event.CreatedFile{
+		Event: e,
+		Name:  name,
+		Type:  subtitlesFileType,
+	})
+}
+
+// commandNames - human readable names of WebDriver commands by method and path relative to session, * matches any path segment
+var commandNames = []struct {
+	method, path, name string
+}{
+	{"DELETE", "", "quit"},
+	{"POST", "url", "navigate to"},
+	{"GET", "url", "get current URL"},
+	{"POST", "back", "go back"},
+	{"POST", "forward", "go forward"},
+	{"POST", "refresh", "refresh page"},
+	{"GET", "title", "get title"},
+	{"GET", "source", "get page source"},
+	{"GET", "screenshot", "take screenshot"},
+	{"GET", "element/*/screenshot", "take element screenshot"},
+	{"POST", "timeouts", "set timeouts"},
+	{"POST", "element", "find element"},
+	{"POST", "elements", "find elements"},
+	{"POST", "element/*/element", "find child element"},
+	{"POST", "element/*/elements", "find child elements"},
+	{"GET", "element/active", "get active element"},
+	{"POST", "element/*/click", "click element"},
+	{"POST", "element/*/clear", "clear element"},
+	{"POST", "element/*/value", "send keys to element"},
+	{"GET", "element/*/text", "get element text"},
+	{"GET", "element/*/name", "get element tag name"},
+	{"GET", "element/*/attribute/*", "get element attribute"},
+	{"GET", "element/*/property/*", "get element property"},
+	{"GET", "element/*/css/*", "get element CSS value"},
+	{"GET", "element/*/rect", "get element rect"},
+	{"GET", "element/*/displayed", "check element is displayed"},
+	{"GET", "element/*/enabled", "check element is enabled"},
+	{"GET", "element/*/selected", "check element is selected"},
+	{"POST", "execute/sync", "execute script"},
+	{"POST", "execute", "execute script"},
+	{"POST", "execute/async", "execute async script"},
+	{"POST", "execute_async", "execute async script"},
+	{"GET", "window", "get window handle"},
+	{"POST", "window", "switch to window"},
+	{"DELETE", "window", "close window"},
+	{"POST", "window/new", "open new window"},
+	{"GET", "window/handles", "get window handles"},
+	{"GET", "window/rect", "get window rect"},
+	{"POST", "window/rect", "set window rect"},
+	{"POST", "window/maximize", "maximize window"},
+	{"POST", "window/minimize", "minimize window"},
+	{"POST", "window/fullscreen", "make window fullscreen"},
+	{"POST", "frame", "switch to frame"},
+	{"POST", "frame/parent", "switch to parent frame"},
+	{"GET", "cookie", "get cookies"},
+	{"GET", "cookie/*", "get cookie"},
+	{"POST", "cookie", "add cookie"},
+	{"DELETE", "cookie", "delete cookies"},
+	{"DELETE", "cookie/*", "delete cookie"},
+	{"POST", "actions", "perform actions"},
+	{"DELETE", "actions", "release actions"},
+	{"POST", "alert/accept", "accept alert"},
+	{"POST", "alert/dismiss", "dismiss alert"},
+	{"GET", "alert/text", "get alert text"},
+	{"POST", "alert/text", "send alert text"},
+	{"POST", "print", "print page"},
+}
+
+// commandName - caption for WebDriver command, request body is used to show navigated URL and element locator
+func commandName(method string, relativePath string, body []byte) string {
+	segments := strings.Split(strings.Trim(relativePath, slash), slash)
+	for _, c := range commandNames {
+		if c.method != method || !matchSegments(strings.Split(c.path, slash), segments) {
+			continue
+		}
+		var args struct {
+			URL   string `json:"url"`
+			Using string `json:"using"`
+			Value string `json:"value"`
+		}
+		_ = json.Unmarshal(body, &args)
+		switch {
+		case c.path == "url" && args.URL != "":
+			return fmt.Sprintf("%s %s", c.name, args.URL)
+		case strings.HasSuffix(c.path, "element") || strings.HasSuffix(c.path, "elements"):
+			if args.Using != "" && args.Value != "" {
+				return fmt.Sprintf("%s by %s %s", c.name, args.Using, args.Value)
+			}
+		}
+		return c.name
+	}
+	return strings.TrimSpace(method + " " + strings.Join(segments, slash))
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) != len(segments) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != "*" && p != segments[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCommandName(t *testing.T) {
+	for _, c := range []struct {
+		method, path, body, name string
+	}{
+		{http.MethodPost, "/url", `{"url":"https://example.com/"}`, "navigate to https://example.com/"},
+		{http.MethodPost, "/element", `{"using":"css selector","value":"#login"}`, "find element by css selector #login"},
+		{http.MethodPost, "/element/some-id/click", `{}`, "click element"},
+		{http.MethodPost, "/element/some-id/value", `{"text":"secret"}`, "send keys to element"},
+		{http.MethodGet, "/element/some-id/attribute/href", "", "get element attribute"},
+		{http.MethodDelete, "", "", "quit"},
+		{http.MethodGet, "/some/vendor/command", "", "GET some/vendor/command"},
+	} {
+		assert.Equal(t, commandName(c.method, c.path, []byte(c.body)), c.name)
+	}
+}
+
+func TestSubtitlesFile(t *testing.T) {
+	started := time.Now()
+	st := &subtitleTrack{started: started}
+	st.add(started.Add(-time.Second), 100*time.Millisecond, "navigate to https://example.com/")
+	st.add(started.Add(61500*time.Millisecond), 200*time.Millisecond, "click element")
+	st.add(started.Add(500*time.Millisecond), 10*time.Second, "execute script")
+
+	dir, err := os.MkdirTemp("", "selenoid-subtitles")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "video.vtt")
+	assert.NoError(t, st.write(name))
+	data, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	assert.Equal(t, string(data), `WEBVTT
+
+1
+00:00:00.000 --> 00:00:00.500
+navigate to https://example.com/
+
+2
+00:00:00.500 --> 00:00:10.500
+execute script
+
+3
+00:01:01.500 --> 00:01:02.500
+click element
+
+`)
+}
+
+func TestSubtitlesRecordedForVideoSessions(t *testing.T) {
+	manager = &HTTPTest{Handler: Selenium()}
+
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", bytes.NewReader([]byte(`{"desiredCapabilities":{"enableVideo":true}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var sess map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sess))
+	sid := sess["sessionId"]
+
+	resp, err = http.Post(With(srv.URL).Path("/wd/hub/session/"+sid+"/url"), "", strings.NewReader(`{"url":"https://example.com/"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	st, ok := getSubtitles(sid)
+	assert.True(t, ok)
+	st.lock.Lock()
+	assert.Len(t, st.cues, 1)
+	assert.Equal(t, st.cues[0].text, "navigate to https://example.com/")
+	st.lock.Unlock()
+
+	req, _ := http.NewRequest(http.MethodDelete, With(srv.URL).Path("/wd/hub/session/"+sid), nil)
+	_, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	waitFor(t, func() bool {
+		_, ok := getSubtitles(sid)
+		return !ok
+	})
+}
+
+func TestSubtitlesEscaping(t *testing.T) {
+	started := time.Now()
+	st := &subtitleTrack{started: started}
+	st.add(started, time.Second, "find element by xpath //a[text()='<b> & -->']\r\nnext\nline")
+
+	f, err := os.CreateTemp("", "selenoid*.vtt")
+	assert.NoError(t, err)
+	_ = f.Close()
+	defer os.Remove(f.Name())
+	assert.NoError(t, st.write(f.Name()))
+	data, err := os.ReadFile(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, string(data), `WEBVTT
+
+1
+00:00:00.000 --> 00:00:01.000
+find element by xpath //a[text()='&lt;b&gt; &amp; --&gt;'] next line
+
+`)
+}
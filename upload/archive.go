@@ -0,0 +1,172 @@
+package upload
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+)
+
+func init() {
+	archive := &ArchiveUploader{}
+	flag.StringVar(&(archive.Dir), "archive-dir", "", "Directory to move recorded files to")
+	flag.StringVar(&(archive.PathPattern), "archive-path-pattern", "$quota/$date/$fileName", "Archived file path pattern")
+	flag.BoolVar(&(archive.KeepFiles), "archive-keep-files", false, "Keep archived files in place instead of removing them after all uploads succeed")
+	flag.StringVar(&(archive.IncludeFiles), "archive-include-files", "", "Pattern used to match and include archived files")
+	flag.StringVar(&(archive.ExcludeFiles), "archive-exclude-files", "", "Pattern used to match and exclude archived files")
+	AddUploader(archive)
+}
+
+// ArchiveUploader - copies recorded files to local or network directory tree
+type ArchiveUploader struct {
+	Dir          string `json:"dir"`
+	PathPattern  string `json:"pathPattern"`
+	KeepFiles    bool   `json:"keepFiles"`
+	IncludeFiles string `json:"includeFiles"`
+	ExcludeFiles string `json:"excludeFiles"`
+}
+
+func (a *ArchiveUploader) Init() {
+	if a.Dir != "" {
+		dir, err := filepath.Abs(a.Dir)
+		if err != nil {
+			log.Fatalf("[-] [INIT] [Invalid archive dir %s: %v]", a.Dir, err)
+		}
+		a.Dir = dir
+		err = os.MkdirAll(a.Dir, os.FileMode(0755))
+		if err != nil {
+			log.Fatalf("[-] [INIT] [Failed to create archive dir %s: %v]", a.Dir, err)
+		}
+		log.Printf("[-] [INIT] [Initialized archive support: dir = %s, pathPattern = %s, keepFiles = %t, includeFiles = %s, excludeFiles = %s]", a.Dir, a.PathPattern, a.KeepFiles, a.IncludeFiles, a.ExcludeFiles)
+	}
+}
+
+func (a *ArchiveUploader) Configured() bool {
+	return a.Dir != ""
+}
+
+func (a *ArchiveUploader) Upload(createdFile event.CreatedFile) (bool, error) {
+	_, uploaded, err := a.UploadTo(createdFile)
+	return uploaded, err
+}
+
+func (a *ArchiveUploader) UploadTo(createdFile event.CreatedFile) (string, bool, error) {
+	if a.Dir == "" {
+		return "", false, errors.New("archive uploader is not initialized")
+	}
+	filename := createdFile.Name
+	fileMatches, err := FileMatches(a.IncludeFiles, a.ExcludeFiles, filename)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid pattern: %v", err)
+	}
+	if !fileMatches {
+		log.Printf("[%d] [SKIPPING_FILE] [%s] [Does not match specified patterns]", createdFile.RequestId, createdFile.Name)
+		return "", false, nil
+	}
+	dst := a.archivePath(createdFile)
+	err = os.MkdirAll(filepath.Dir(dst), os.FileMode(0755))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create archive directory for %s: %v", dst, err)
+	}
+	tmp, err := stage(filename, dst)
+	if err != nil {
+		return "", false, err
+	}
+	archived, err := place(tmp, dst)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", false, fmt.Errorf("failed to archive %s as %s: %v", filename, dst, err)
+	}
+	log.Printf("[%d] [ARCHIVED_FILE] [%s] [%s]", createdFile.RequestId, filename, archived)
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(archived)}).String(), true, nil
+}
+
+func (a *ArchiveUploader) KeepsFiles() bool {
+	return a.KeepFiles
+}
+
+// Link - archived files are served by Selenoid, so local file URL is returned
+func (a *ArchiveUploader) Link(location string, _ time.Duration) (string, bool, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "file" || a.Dir == "" {
+		return "", false, nil
+	}
+	rel, err := filepath.Rel(a.Dir, filepath.FromSlash(u.Path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false, nil
+	}
+	return location, true, nil
+}
+
+func (a *ArchiveUploader) archivePath(createdFile event.CreatedFile) string {
+	key := GetKey(a.PathPattern, createdFile)
+	// Capabilities are controlled by users, so resulting path should never leave archive directory
+	return filepath.Join(a.Dir, filepath.FromSlash(path.Clean("/"+filepath.ToSlash(key))))
+}
+
+// stage puts file contents to a temporary file in destination directory, source file is left for other uploaders
+func stage(filename string, dst string) (string, error) {
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+"."+randomId()+".tmp")
+	// Hard link is enough on the same file system as source file is never modified
+	if os.Link(filename, tmp) == nil {
+		return tmp, nil
+	}
+	src, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %s: %v", filename, err)
+	}
+	defer src.Close()
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file %s: %v", tmp, err)
+	}
+	_, err = io.Copy(f, src)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("failed to copy %s to %s: %v", filename, tmp, err)
+	}
+	return tmp, nil
+}
+
+// place atomically moves staged file to destination adding a numeric suffix when destination already exists
+func place(tmp string, dst string) (string, error) {
+	const maxCollisions = 1000
+	ext := filepath.Ext(dst)
+	base := strings.TrimSuffix(dst, ext)
+	for i := 0; i < maxCollisions; i++ {
+		candidate := dst
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		err := os.Link(tmp, candidate)
+		if err == nil {
+			_ = os.Remove(tmp)
+			return candidate, nil
+		}
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		// Hard links are not supported by some file systems
+		if _, statErr := os.Stat(candidate); statErr == nil {
+			continue
+		}
+		return candidate, os.Rename(tmp, candidate)
+	}
+	return "", fmt.Errorf("too many files named %s", dst)
+}
@@ -0,0 +1,253 @@
+package upload
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	catalogFile = "artifacts.jsonl"
+	// CatalogSessions - uploaded files of only this many latest sessions are remembered
+	CatalogSessions = 10000
+	// Catalog file is compacted when it has more obsolete lines than remembered artifacts and at least this many lines
+	catalogCompactLines = 1000
+)
+
+// Artifact - uploaded file location
+type Artifact struct {
+	Name      string    `json:"name"`
+	Type      string    `json:"type,omitempty"`
+	SessionId string    `json:"sessionId,omitempty"`
+	Location  string    `json:"location,omitempty"`
+	Uploaded  time.Time `json:"uploaded,omitempty"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+// Catalog - remembers where files of limited number of the latest sessions were uploaded,
+// optionally persisted to append-only JSONL file compacted from time to time
+type Catalog struct {
+	lock      sync.RWMutex
+	limit     int
+	artifacts map[string][]Artifact
+	// order - session keys from the oldest to the latest one
+	order     *list.List
+	sessions  map[string]*list.Element
+	bySession map[string]map[string]bool
+	// count - remembered artifacts, lines - artifacts and deletions saved to file since last compaction
+	count    int
+	lines    int
+	filename string
+}
+
+// NewCatalog creates catalog remembering files of limit latest sessions, it is only kept in memory when directory is empty
+func NewCatalog(dir string, limit int) (*Catalog, error) {
+	c := &Catalog{
+		limit:     limit,
+		artifacts: make(map[string][]Artifact),
+		order:     list.New(),
+		sessions:  make(map[string]*list.Element),
+		bySession: make(map[string]map[string]bool),
+	}
+	if dir == "" {
+		return c, nil
+	}
+	c.filename = filepath.Join(dir, catalogFile)
+	err := c.load()
+	if err != nil {
+		return nil, fmt.Errorf("load artifacts catalog %s: %v", c.filename, err)
+	}
+	return c, nil
+}
+
+func (c *Catalog) load() error {
+	f, err := os.Open(c.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var a Artifact
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			continue
+		}
+		c.apply(a)
+	}
+	_ = f.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return c.compact()
+}
+
+// compact - rewrite catalog file without deleted and forgotten artifacts
+func (c *Catalog) compact() error {
+	var all []Artifact
+	for _, as := range c.artifacts {
+		all = append(all, as...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Uploaded.Before(all[j].Uploaded)
+	})
+	tmp := c.filename + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	for _, a := range all {
+		_ = enc.Encode(a)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.filename); err != nil {
+		return err
+	}
+	c.lines = c.count
+	return nil
+}
+
+// sessionKey - files uploaded without session are remembered as separate sessions
+func sessionKey(a Artifact) string {
+	if a.SessionId != "" {
+		return a.SessionId
+	}
+	return a.Name
+}
+
+func (c *Catalog) apply(a Artifact) {
+	if a.Deleted {
+		c.remove(a.Name)
+		return
+	}
+	key := sessionKey(a)
+	// File recreated by another session belongs to it only
+	if as, ok := c.artifacts[a.Name]; ok && sessionKey(as[0]) != key {
+		c.remove(a.Name)
+	}
+	if _, ok := c.sessions[key]; !ok {
+		c.sessions[key] = c.order.PushBack(key)
+		c.bySession[key] = make(map[string]bool)
+	}
+	c.bySession[key][a.Name] = true
+	c.artifacts[a.Name] = append(c.artifacts[a.Name], a)
+	c.count++
+	for c.limit > 0 && c.order.Len() > c.limit {
+		oldest := c.order.Front().Value.(string)
+		for name := range c.bySession[oldest] {
+			c.remove(name)
+		}
+	}
+}
+
+// remove - forget all locations of file and its session when it has no more files
+func (c *Catalog) remove(name string) {
+	as, ok := c.artifacts[name]
+	if !ok {
+		return
+	}
+	key := sessionKey(as[0])
+	delete(c.bySession[key], name)
+	if len(c.bySession[key]) == 0 {
+		delete(c.bySession, key)
+		c.order.Remove(c.sessions[key])
+		delete(c.sessions, key)
+	}
+	c.count -= len(as)
+	delete(c.artifacts, name)
+}
+
+func (c *Catalog) append(a Artifact) {
+	c.apply(a)
+	c.lines++
+	if c.filename == "" {
+		return
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(c.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err == nil {
+		_, err = f.Write(append(data, '\n'))
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Printf("[-] [UPLOAD_CATALOG] [Failed to save artifact %s: %v]", a.Name, err)
+		return
+	}
+	if c.lines >= catalogCompactLines && c.lines > 2*c.count {
+		if err := c.compact(); err != nil {
+			log.Printf("[-] [UPLOAD_CATALOG] [Failed to compact %s: %v]", c.filename, err)
+		}
+	}
+}
+
+// Add - remember uploaded file location
+func (c *Catalog) Add(a Artifact) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.append(a)
+}
+
+// Forget - remove all locations of file name, returns false when file is unknown
+func (c *Catalog) Forget(name string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.artifacts[name]; !ok {
+		return false
+	}
+	c.append(Artifact{Name: name, Deleted: true})
+	return true
+}
+
+// Get - all known locations of file name
+func (c *Catalog) Get(name string) []Artifact {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]Artifact{}, c.artifacts[name]...)
+}
+
+// List - all known artifacts of given types sorted by name
+func (c *Catalog) List(types ...string) []Artifact {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var ret []Artifact
+	for _, as := range c.artifacts {
+		for _, a := range as {
+			if len(types) == 0 || contains(types, a.Type) {
+				ret = append(ret, a)
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+		return ret[i].Location < ret[j].Location
+	})
+	return ret
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
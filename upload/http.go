@@ -0,0 +1,213 @@
+package upload
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+)
+
+func init() {
+	h := &HTTPUploader{Headers: make(Headers)}
+	flag.StringVar(&(h.URL), "http-upload-url", "", "Base URL to upload files to with HTTP PUT requests")
+	flag.StringVar(&(h.KeyPattern), "http-upload-key-pattern", "$fileName", "Uploaded file path pattern relative to base URL")
+	flag.StringVar(&(h.Token), "http-upload-token", "", "Bearer token sent with HTTP upload requests")
+	flag.Var(h.Headers, "http-upload-header", "Header sent with HTTP upload requests as Name:value, can be repeated")
+	flag.DurationVar((*time.Duration)(&h.Timeout), "http-upload-timeout", 10*time.Minute, "HTTP upload request timeout in time.Duration format")
+	flag.BoolVar(&(h.CreateDirs), "http-upload-create-dirs", false, "Create parent collections with WebDAV MKCOL requests before upload")
+	flag.BoolVar(&(h.KeepFiles), "http-upload-keep-files", false, "Do not remove uploaded files")
+	flag.StringVar(&(h.IncludeFiles), "http-upload-include-files", "", "Pattern used to match and include uploaded files")
+	flag.StringVar(&(h.ExcludeFiles), "http-upload-exclude-files", "", "Pattern used to match and exclude uploaded files")
+	AddUploader(h)
+}
+
+// Headers - repeatable HTTP header flag
+type Headers http.Header
+
+func (h Headers) String() string {
+	var ret []string
+	for k, vs := range h {
+		for _, v := range vs {
+			ret = append(ret, k+":"+v)
+		}
+	}
+	return strings.Join(ret, ",")
+}
+
+func (h Headers) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	k = strings.TrimSpace(k)
+	if !ok || k == "" {
+		return fmt.Errorf("invalid header %q: expected Name:value", s)
+	}
+	http.Header(h).Add(k, strings.TrimSpace(v))
+	return nil
+}
+
+// HTTPUploader - uploads files to WebDAV or any other storage accepting HTTP PUT requests
+type HTTPUploader struct {
+	URL          string   `json:"url"`
+	KeyPattern   string   `json:"keyPattern"`
+	Token        string   `json:"token"`
+	Headers      Headers  `json:"headers"`
+	Timeout      Duration `json:"timeout"`
+	CreateDirs   bool     `json:"createDirs"`
+	KeepFiles    bool     `json:"keepFiles"`
+	IncludeFiles string   `json:"includeFiles"`
+	ExcludeFiles string   `json:"excludeFiles"`
+
+	base   *url.URL
+	client *http.Client
+}
+
+func (h *HTTPUploader) Init() {
+	if h.URL != "" {
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("[-] [INIT] [Invalid HTTP upload URL %s]", h.URL)
+		}
+		h.base = u
+		h.client = &http.Client{Timeout: time.Duration(h.Timeout)}
+		log.Printf("[-] [INIT] [Initialized HTTP upload support: url = %s, keyPattern = %s, createDirs = %t, includeFiles = %s, excludeFiles = %s]", u.Redacted(), h.KeyPattern, h.CreateDirs, h.IncludeFiles, h.ExcludeFiles)
+	}
+}
+
+func (h *HTTPUploader) Configured() bool {
+	return h.client != nil
+}
+
+func (h *HTTPUploader) Upload(createdFile event.CreatedFile) (bool, error) {
+	_, uploaded, err := h.UploadTo(createdFile)
+	return uploaded, err
+}
+
+func (h *HTTPUploader) UploadTo(createdFile event.CreatedFile) (string, bool, error) {
+	if h.client == nil {
+		return "", false, errors.New("HTTP uploader is not initialized")
+	}
+	filename := createdFile.Name
+	fileMatches, err := FileMatches(h.IncludeFiles, h.ExcludeFiles, filename)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid pattern: %v", err)
+	}
+	if !fileMatches {
+		log.Printf("[%d] [SKIPPING_FILE] [%s] [Does not match specified patterns]", createdFile.RequestId, createdFile.Name)
+		return "", false, nil
+	}
+	key := strings.TrimPrefix(path.Clean("/"+GetKey(h.KeyPattern, createdFile)), "/")
+	if h.CreateDirs {
+		err := h.mkcol(path.Dir(key))
+		if err != nil {
+			return "", false, fmt.Errorf("failed to create collection for %s: %v", key, err)
+		}
+	}
+	err = h.put(filename, key)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to HTTP upload %s as %s: %v", filename, key, err)
+	}
+	return h.location().JoinPath(key).String(), true, nil
+}
+
+func (h *HTTPUploader) KeepsFiles() bool {
+	return h.KeepFiles
+}
+
+// Link - uploaded files are downloaded directly from upload location
+func (h *HTTPUploader) Link(location string, _ time.Duration) (string, bool, error) {
+	if h.base == nil || !strings.HasPrefix(location, h.location().String()+"/") {
+		return "", false, nil
+	}
+	return location, true, nil
+}
+
+// location - base URL without credentials
+func (h *HTTPUploader) location() *url.URL {
+	u := *h.base
+	u.User = nil
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	return &u
+}
+
+func (h *HTTPUploader) put(filename string, key string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %v", filename, err)
+	}
+	defer file.Close()
+	req, err := h.request(http.MethodPut, key, file)
+	if err != nil {
+		return err
+	}
+	// File body is streamed with chunked transfer encoding, GetBody allows to resend it on redirects
+	req.GetBody = func() (io.ReadCloser, error) {
+		return os.Open(filename)
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return h.do(req, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+}
+
+func (h *HTTPUploader) mkcol(dir string) error {
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	var current string
+	for _, fragment := range strings.Split(dir, "/") {
+		current = path.Join(current, fragment)
+		req, err := h.request("MKCOL", current+"/", nil)
+		if err != nil {
+			return err
+		}
+		// 405 Method Not Allowed is returned by WebDAV servers when collection already exists
+		err = h.do(req, http.StatusOK, http.StatusCreated, http.StatusMethodNotAllowed)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *HTTPUploader) request(method string, key string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, h.base.JoinPath(key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range h.Headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+	return req, nil
+}
+
+func (h *HTTPUploader) do(req *http.Request, expected ...int) error {
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status)
+}
@@ -0,0 +1,55 @@
+package upload
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+)
+
+func FileMatches(includedFiles string, excludedFiles string, filename string) (bool, error) {
+	fileIncluded := true
+	if includedFiles != "" {
+		fi, err := filepath.Match(includedFiles, filepath.Base(filename))
+		if err != nil {
+			return false, fmt.Errorf("failed to match included file: %v", err)
+		}
+		fileIncluded = fi
+	}
+	fileExcluded := false
+	if excludedFiles != "" {
+		fe, err := filepath.Match(excludedFiles, filepath.Base(filename))
+		if err != nil {
+			return false, fmt.Errorf("failed to match excluded file: %v", err)
+		}
+		fileExcluded = fe
+	}
+	return fileIncluded && !fileExcluded, nil
+}
+
+func GetS3Key(keyPattern string, createdFile event.CreatedFile) string {
+	pt := keyPattern
+	if createdFile.Session.Caps.S3KeyPattern != "" {
+		pt = createdFile.Session.Caps.S3KeyPattern
+	}
+	return GetKey(pt, createdFile)
+}
+
+// GetKey - replace placeholders in key pattern with created file and session information
+func GetKey(keyPattern string, createdFile event.CreatedFile) string {
+	sess := createdFile.Session
+	filename := createdFile.Name
+	key := strings.Replace(keyPattern, "$fileName", filepath.Base(filename), -1)
+	key = strings.Replace(key, "$fileExtension", strings.ToLower(filepath.Ext(filename)), -1)
+	key = strings.Replace(key, "$browserName", strings.ToLower(sess.Caps.BrowserName()), -1)
+	key = strings.Replace(key, "$browserVersion", strings.ToLower(sess.Caps.Version), -1)
+	key = strings.Replace(key, "$platformName", strings.ToLower(sess.Caps.Platform), -1)
+	key = strings.Replace(key, "$quota", strings.ToLower(sess.Quota), -1)
+	key = strings.Replace(key, "$sessionId", createdFile.SessionId, -1)
+	key = strings.Replace(key, "$fileType", strings.ToLower(createdFile.Type), -1)
+	key = strings.Replace(key, "$date", time.Now().Format("2006-01-02"), -1)
+	key = strings.Replace(key, " ", "-", -1)
+	return key
+}
@@ -0,0 +1,416 @@
+package upload
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+	"github.com/aerokube/selenoid/info"
+	"github.com/aerokube/selenoid/session"
+)
+
+const (
+	TaskPending   = "pending"
+	TaskUploading = "uploading"
+	TaskFailed    = "failed"
+	TaskCompleted = "completed"
+
+	manifestExtension = ".json"
+	maxCompletedTasks = 100
+	maxRetryDelay     = time.Hour
+)
+
+// Task - file to be uploaded by one of uploaders, saved to spool directory as JSON manifest
+type Task struct {
+	ID          string       `json:"id"`
+	Uploader    int          `json:"uploader"`
+	RequestId   uint64       `json:"requestId"`
+	SessionId   string       `json:"sessionId"`
+	Quota       string       `json:"quota"`
+	Caps        session.Caps `json:"caps"`
+	Started     time.Time    `json:"started"`
+	Name        string       `json:"name"`
+	Type        string       `json:"type"`
+	State       string       `json:"state"`
+	Attempts    int          `json:"attempts"`
+	NextAttempt time.Time    `json:"nextAttempt,omitempty"`
+	LastError   string       `json:"lastError,omitempty"`
+	Finished    time.Time    `json:"finished,omitempty"`
+	// Results of already finished uploads of the same file, local file is removed after the last upload
+	FileUploaded bool `json:"fileUploaded,omitempty"`
+	KeepFile     bool `json:"keepFile,omitempty"`
+
+	timer *time.Timer
+}
+
+func (t *Task) createdFile() event.CreatedFile {
+	return event.CreatedFile{
+		Event: event.Event{
+			RequestId: t.RequestId,
+			SessionId: t.SessionId,
+			Session: &session.Session{
+				Quota:   t.Quota,
+				Caps:    t.Caps,
+				Started: t.Started,
+			},
+		},
+		Name: t.Name,
+		Type: t.Type,
+	}
+}
+
+// Status - upload queue contents
+type Status struct {
+	Pending   []Task `json:"pending"`
+	Failed    []Task `json:"failed"`
+	Completed []Task `json:"completed"`
+}
+
+// Queue - persistent upload queue with retries
+type Queue struct {
+	uploaders []Uploader
+	dir       string
+	workers   int
+	retries   int
+	delay     time.Duration
+
+	lock      sync.Mutex
+	cond      *sync.Cond
+	tasks     map[string]*Task
+	due       []string
+	completed []*Task
+	stopped   bool
+	wg        sync.WaitGroup
+
+	catalog *Catalog
+}
+
+// NewQueue creates upload queue, tasks are only kept in memory when spool directory is empty
+func NewQueue(uploaders []Uploader, dir string, workers int, retries int, delay time.Duration) (*Queue, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("invalid number of upload workers: %d", workers)
+	}
+	q := &Queue{
+		uploaders: uploaders,
+		dir:       dir,
+		workers:   workers,
+		retries:   retries,
+		delay:     delay,
+		tasks:     make(map[string]*Task),
+	}
+	q.cond = sync.NewCond(&q.lock)
+	if dir != "" {
+		err := os.MkdirAll(dir, os.FileMode(0755))
+		if err != nil {
+			return nil, fmt.Errorf("create spool dir %s: %v", dir, err)
+		}
+		err = q.load()
+		if err != nil {
+			return nil, fmt.Errorf("load spool dir %s: %v", dir, err)
+		}
+	}
+	return q, nil
+}
+
+func (q *Queue) load() error {
+	files, err := os.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != manifestExtension {
+			continue
+		}
+		filename := filepath.Join(q.dir, f.Name())
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		var t Task
+		if err := json.Unmarshal(data, &t); err != nil {
+			log.Printf("[-] [UPLOAD_QUEUE] [Skipping broken manifest %s: %v]", filename, err)
+			continue
+		}
+		if t.Uploader < 0 || t.Uploader >= len(q.uploaders) {
+			log.Printf("[-] [UPLOAD_QUEUE] [Skipping manifest %s: unknown uploader %d]", filename, t.Uploader)
+			continue
+		}
+		if t.State != TaskFailed {
+			t.State = TaskPending
+			q.due = append(q.due, t.ID)
+		}
+		q.tasks[t.ID] = &t
+	}
+	if len(q.tasks) > 0 {
+		log.Printf("[-] [UPLOAD_QUEUE] [Loaded %d pending uploads from %s]", len(q.tasks), q.dir)
+	}
+	return nil
+}
+
+// Start - start upload workers
+func (q *Queue) Start() {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+}
+
+// Stop - finish uploads that are due and stop workers, uploads waiting for retry are kept in spool directory
+func (q *Queue) Stop() {
+	q.lock.Lock()
+	q.stopped = true
+	waiting := 0
+	for _, t := range q.tasks {
+		if t.timer != nil && t.timer.Stop() {
+			t.timer = nil
+			waiting++
+		}
+	}
+	q.cond.Broadcast()
+	q.lock.Unlock()
+	q.wg.Wait()
+	if waiting > 0 {
+		log.Printf("[-] [UPLOAD_QUEUE] [%d uploads left waiting for retry]", waiting)
+	}
+}
+
+// Add - enqueue created file for every uploader
+func (q *Queue) Add(createdFile event.CreatedFile) {
+	sess := createdFile.Session
+	// All uploads of the file are enqueued together, so that the file is not removed after the first one
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for i := range q.uploaders {
+		t := &Task{
+			ID:        randomId(),
+			Uploader:  i,
+			RequestId: createdFile.RequestId,
+			SessionId: createdFile.SessionId,
+			Quota:     sess.Quota,
+			Caps:      sess.Caps,
+			Started:   sess.Started,
+			Name:      createdFile.Name,
+			Type:      createdFile.Type,
+			State:     TaskPending,
+		}
+		q.tasks[t.ID] = t
+		q.save(t)
+		q.due = append(q.due, t.ID)
+		q.cond.Signal()
+	}
+}
+
+// Retry - upload failed or waiting task right now
+func (q *Queue) Retry(id string) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	t, ok := q.tasks[id]
+	if !ok || t.State == TaskUploading {
+		return false
+	}
+	if t.timer != nil {
+		if !t.timer.Stop() {
+			return true
+		}
+		t.timer = nil
+	}
+	if t.State == TaskFailed {
+		t.Attempts = 0
+	}
+	t.State = TaskPending
+	t.NextAttempt = time.Time{}
+	q.save(t)
+	q.due = append(q.due, t.ID)
+	q.cond.Signal()
+	return true
+}
+
+// Status - list pending, failed and recently completed tasks
+func (q *Queue) Status() Status {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	ret := Status{Pending: []Task{}, Failed: []Task{}, Completed: []Task{}}
+	for _, t := range q.tasks {
+		if t.State == TaskFailed {
+			ret.Failed = append(ret.Failed, *t)
+		} else {
+			ret.Pending = append(ret.Pending, *t)
+		}
+	}
+	for _, t := range q.completed {
+		ret.Completed = append(ret.Completed, *t)
+	}
+	byName := func(tasks []Task) {
+		sort.Slice(tasks, func(i, j int) bool {
+			return tasks[i].Name < tasks[j].Name
+		})
+	}
+	byName(ret.Pending)
+	byName(ret.Failed)
+	return ret
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for {
+		q.lock.Lock()
+		for len(q.due) == 0 && !q.stopped {
+			q.cond.Wait()
+		}
+		if len(q.due) == 0 {
+			q.lock.Unlock()
+			return
+		}
+		id := q.due[0]
+		q.due = q.due[1:]
+		t, ok := q.tasks[id]
+		if !ok || t.State != TaskPending {
+			q.lock.Unlock()
+			continue
+		}
+		t.State = TaskUploading
+		t.Attempts++
+		createdFile := t.createdFile()
+		uploader := q.uploaders[t.Uploader]
+		q.lock.Unlock()
+
+		s := time.Now()
+		location, uploaded, err := uploadTo(uploader, createdFile)
+		if uploaded && location != "" && q.catalog != nil {
+			q.catalog.Add(Artifact{
+				Name:      filepath.Base(t.Name),
+				Type:      t.Type,
+				SessionId: t.SessionId,
+				Location:  location,
+				Uploaded:  time.Now(),
+			})
+		}
+		q.done(t, uploaded, err, s)
+	}
+}
+
+func (q *Queue) done(t *Task, uploaded bool, err error, started time.Time) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if err == nil {
+		delete(q.tasks, t.ID)
+		q.remove(t)
+		q.release(t, uploaded)
+		if !uploaded {
+			return
+		}
+		log.Printf("[%d] [UPLOADED_FILE] [%s] [%.2fs]", t.RequestId, t.Name, info.SecondsSince(started))
+		t.State = TaskCompleted
+		t.LastError = ""
+		t.Finished = time.Now()
+		q.completed = append(q.completed, t)
+		if len(q.completed) > maxCompletedTasks {
+			q.completed = q.completed[1:]
+		}
+		return
+	}
+	t.LastError = err.Error()
+	if t.Attempts > q.retries {
+		log.Printf("[%d] [UPLOADING_FILE] [%s] [Failed to upload after %d attempts: %v]", t.RequestId, t.Name, t.Attempts, err)
+		t.State = TaskFailed
+		q.save(t)
+		return
+	}
+	delay := backoff(q.delay, t.Attempts)
+	log.Printf("[%d] [UPLOADING_FILE] [%s] [Failed to upload, retrying in %s: %v]", t.RequestId, t.Name, delay, err)
+	t.State = TaskPending
+	t.NextAttempt = time.Now().Add(delay)
+	q.save(t)
+	if q.stopped {
+		return
+	}
+	t.timer = time.AfterFunc(delay, func() {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		t.timer = nil
+		t.NextAttempt = time.Time{}
+		q.due = append(q.due, t.ID)
+		q.cond.Signal()
+	})
+}
+
+// release - passes upload result to other uploads of the same file, local file is removed when the last upload succeeds and no uploader keeps it
+func (q *Queue) release(t *Task, uploaded bool) {
+	fileUploaded := t.FileUploaded || uploaded
+	keepFile := t.KeepFile || (uploaded && keepsFiles(q.uploaders[t.Uploader]))
+	last := true
+	for _, other := range q.tasks {
+		if other.Name != t.Name {
+			continue
+		}
+		last = false
+		other.FileUploaded = other.FileUploaded || fileUploaded
+		other.KeepFile = other.KeepFile || keepFile
+		q.save(other)
+	}
+	if !last || !fileUploaded || keepFile {
+		return
+	}
+	err := os.Remove(t.Name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[%d] [UPLOADING_FILE] [%s] [Failed to remove uploaded file: %v]", t.RequestId, t.Name, err)
+	}
+}
+
+func backoff(delay time.Duration, attempt int) time.Duration {
+	ret := delay
+	for i := 1; i < attempt && ret < maxRetryDelay; i++ {
+		ret *= 2
+	}
+	return min(ret, maxRetryDelay)
+}
+
+func (q *Queue) manifest(t *Task) string {
+	return filepath.Join(q.dir, t.ID+manifestExtension)
+}
+
+func (q *Queue) save(t *Task) {
+	if q.dir == "" {
+		return
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		log.Printf("[%d] [UPLOAD_QUEUE] [Failed to marshal manifest for %s: %v]", t.RequestId, t.Name, err)
+		return
+	}
+	filename := q.manifest(t)
+	tmp := filename + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		log.Printf("[%d] [UPLOAD_QUEUE] [Failed to save manifest %s: %v]", t.RequestId, filename, err)
+	}
+}
+
+func (q *Queue) remove(t *Task) {
+	if q.dir == "" {
+		return
+	}
+	filename := q.manifest(t)
+	err := os.Remove(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[%d] [UPLOAD_QUEUE] [Failed to remove manifest %s: %v]", t.RequestId, filename, err)
+	}
+}
+
+func randomId() string {
+	randBytes := make([]byte, 16)
+	_, _ = rand.Read(randBytes)
+	return hex.EncodeToString(randBytes)
+}
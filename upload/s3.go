@@ -8,15 +8,20 @@ import (
 	"fmt"
 	"log"
 	"mime"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/aerokube/selenoid/event"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	awssession "github.com/aws/aws-sdk-go/aws/session"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/pkg/errors"
 )
@@ -30,27 +35,41 @@ func init() {
 	flag.StringVar(&(s3.BucketName), "s3-bucket-name", "", "S3 bucket name")
 	flag.StringVar(&(s3.KeyPattern), "s3-key-pattern", "$fileName", "S3 bucket name")
 	flag.BoolVar(&(s3.ReducedRedundancy), "s3-reduced-redundancy", false, "Use reduced redundancy storage class")
+	flag.StringVar(&(s3.StorageClass), "s3-storage-class", "", "S3 storage class, e.g. STANDARD_IA or GLACIER_IR")
+	flag.StringVar(&(s3.ServerSideEncryption), "s3-sse", "", "S3 server-side encryption algorithm: AES256 or aws:kms")
+	flag.StringVar(&(s3.SSEKMSKeyId), "s3-sse-kms-key-id", "", "KMS key ID used for aws:kms server-side encryption")
+	flag.StringVar(&(s3.ACL), "s3-acl", "", "S3 canned ACL applied to uploaded files, e.g. bucket-owner-full-control")
+	flag.BoolVar(&(s3.Tagging), "s3-tagging", false, "Add session tags to uploaded files, requires s3:PutObjectTagging permission")
 	flag.BoolVar(&(s3.KeepFiles), "s3-keep-files", false, "Do not remove uploaded files")
 	flag.StringVar(&(s3.IncludeFiles), "s3-include-files", "", "Pattern used to match and include files")
 	flag.StringVar(&(s3.ExcludeFiles), "s3-exclude-files", "", "Pattern used to match and exclude files")
 	flag.BoolVar(&(s3.ForcePathStyle), "s3-force-path-style", false, "Force path-style addressing for file upload")
 	AddUploader(s3)
+	AddTargetType("s3", func() Uploader {
+		return &S3Uploader{KeyPattern: "$fileName"}
+	})
 }
 
 type S3Uploader struct {
-	Endpoint          string
-	Region            string
-	AccessKey         string
-	SecretKey         string
-	BucketName        string
-	KeyPattern        string
-	ReducedRedundancy bool
-	KeepFiles         bool
-	IncludeFiles      string
-	ExcludeFiles      string
-	ForcePathStyle    bool
+	Endpoint             string `json:"endpoint"`
+	Region               string `json:"region"`
+	AccessKey            string `json:"accessKey"`
+	SecretKey            string `json:"secretKey"`
+	BucketName           string `json:"bucketName"`
+	KeyPattern           string `json:"keyPattern"`
+	ReducedRedundancy    bool   `json:"reducedRedundancy"`
+	StorageClass         string `json:"storageClass"`
+	ServerSideEncryption string `json:"sse"`
+	SSEKMSKeyId          string `json:"sseKmsKeyId"`
+	ACL                  string `json:"acl"`
+	Tagging              bool   `json:"tagging"`
+	KeepFiles            bool   `json:"keepFiles"`
+	IncludeFiles         string `json:"includeFiles"`
+	ExcludeFiles         string `json:"excludeFiles"`
+	ForcePathStyle       bool   `json:"forcePathStyle"`
 
 	manager *s3manager.Uploader
+	client  *awss3.S3
 }
 
 func (s3 *S3Uploader) Init() {
@@ -67,91 +86,179 @@ func (s3 *S3Uploader) Init() {
 		if err != nil {
 			log.Fatalf("[-] [INIT] [Failed to initialize S3 support: %v]", err)
 		}
-		log.Printf("[-] [INIT] [Initialized S3 support: endpoint = %s, region = %s, bucketName = %s, accessKey = %s, keyPattern = %s, includeFiles = %s, excludeFiles = %s, forcePathStyle = %t]", s3.Endpoint, s3.Region, s3.BucketName, s3.AccessKey, s3.KeyPattern, s3.IncludeFiles, s3.ExcludeFiles, s3.ForcePathStyle)
+		log.Printf("[-] [INIT] [Initialized S3 support: endpoint = %s, region = %s, bucketName = %s, accessKey = %s, keyPattern = %s, includeFiles = %s, excludeFiles = %s, forcePathStyle = %t, storageClass = %s, sse = %s, acl = %s, tagging = %t]", s3.Endpoint, s3.Region, s3.BucketName, s3.AccessKey, s3.KeyPattern, s3.IncludeFiles, s3.ExcludeFiles, s3.ForcePathStyle, s3.StorageClass, s3.ServerSideEncryption, s3.ACL, s3.Tagging)
 		s3.manager = s3manager.NewUploader(sess)
+		s3.client = awss3.New(sess)
 	}
 }
 
+func (s3 *S3Uploader) Configured() bool {
+	return s3.manager != nil
+}
+
 func (s3 *S3Uploader) Upload(createdFile event.CreatedFile) (bool, error) {
+	_, uploaded, err := s3.UploadTo(createdFile)
+	return uploaded, err
+}
+
+func (s3 *S3Uploader) UploadTo(createdFile event.CreatedFile) (string, bool, error) {
 	if s3.manager != nil {
 		filename := createdFile.Name
 		fileMatches, err := FileMatches(s3.IncludeFiles, s3.ExcludeFiles, filename)
 		if err != nil {
-			return false, fmt.Errorf("invalid pattern: %v", err)
+			return "", false, fmt.Errorf("invalid pattern: %v", err)
 		}
 		if !fileMatches {
 			log.Printf("[%d] [SKIPPING_FILE] [%s] [Does not match specified patterns]", createdFile.RequestId, createdFile.Name)
-			return false, nil
+			return "", false, nil
 		}
 		key := GetS3Key(s3.KeyPattern, createdFile)
 		file, err := os.Open(filename)
 		defer file.Close()
 		if err != nil {
-			return false, fmt.Errorf("failed to open file %s: %v", filename, err)
+			return "", false, fmt.Errorf("failed to open file %s: %v", filename, err)
 		}
 		uploadInput := &s3manager.UploadInput{
-			Bucket: aws.String(s3.BucketName),
-			Key:    aws.String(key),
-			Body:   file,
+			Bucket:   aws.String(s3.BucketName),
+			Key:      aws.String(key),
+			Body:     file,
+			Metadata: aws.StringMap(objectMetadata(createdFile)),
 		}
 		contentType := mime.TypeByExtension(filepath.Ext(filename))
 		if contentType != "" {
 			uploadInput.ContentType = aws.String(contentType)
 		}
-		if s3.ReducedRedundancy {
+		if s3.StorageClass != "" {
+			uploadInput.StorageClass = aws.String(s3.StorageClass)
+		} else if s3.ReducedRedundancy {
 			uploadInput.StorageClass = aws.String("REDUCED_REDUNDANCY")
 		}
+		if s3.Tagging {
+			uploadInput.Tagging = aws.String(objectTags(createdFile))
+		}
+		if s3.ServerSideEncryption != "" {
+			uploadInput.ServerSideEncryption = aws.String(s3.ServerSideEncryption)
+		}
+		if s3.SSEKMSKeyId != "" {
+			uploadInput.SSEKMSKeyId = aws.String(s3.SSEKMSKeyId)
+		}
+		if s3.ACL != "" {
+			uploadInput.ACL = aws.String(s3.ACL)
+		}
 		_, err = s3.manager.Upload(uploadInput)
 		if err != nil {
-			return false, fmt.Errorf("failed to S3 upload %s as %s: %v", filename, key, err)
-		}
-		if !s3.KeepFiles {
-			err := os.Remove(filename)
-			if err != nil {
-				return true, fmt.Errorf("failed to remove uploaded file %s: %v", filename, err)
-			}
+			return "", false, fmt.Errorf("failed to S3 upload %s as %s: %v", filename, key, err)
 		}
-		return true, nil
+		location := (&url.URL{Scheme: "s3", Host: s3.BucketName, Path: "/" + key}).String()
+		return location, true, nil
 	}
-	return false, errors.New("S3 uploader is not initialized")
+	return "", false, errors.New("S3 uploader is not initialized")
 }
 
-func FileMatches(includedFiles string, excludedFiles string, filename string) (bool, error) {
-	fileIncluded := true
-	if includedFiles != "" {
-		fi, err := filepath.Match(includedFiles, filepath.Base(filename))
-		if err != nil {
-			return false, fmt.Errorf("failed to match included file: %v", err)
-		}
-		fileIncluded = fi
+func (s3 *S3Uploader) KeepsFiles() bool {
+	return s3.KeepFiles
+}
+
+// Link - presigned URL to download uploaded file from
+func (s3 *S3Uploader) Link(location string, expires time.Duration) (string, bool, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "s3" || u.Host != s3.BucketName || s3.client == nil {
+		return "", false, nil
 	}
-	fileExcluded := false
-	if excludedFiles != "" {
-		fe, err := filepath.Match(excludedFiles, filepath.Base(filename))
-		if err != nil {
-			return false, fmt.Errorf("failed to match excluded file: %v", err)
+	req, _ := s3.client.GetObjectRequest(&awss3.GetObjectInput{
+		Bucket: aws.String(s3.BucketName),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	})
+	link, err := req.Presign(expires)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to presign %s: %v", location, err)
+	}
+	return link, true, nil
+}
+
+// objectMetadata - session information saved as S3 user-defined metadata, non-ASCII values are MIME encoded
+func objectMetadata(createdFile event.CreatedFile) map[string]string {
+	ret := make(map[string]string)
+	for k, v := range sessionAttributes(createdFile) {
+		ret[metadataKey(k)] = mime.QEncoding.Encode("utf-8", v)
+	}
+	return ret
+}
+
+// objectTags - session information as S3 tag set, S3 allows at most 10 tags with limited character set
+func objectTags(createdFile event.CreatedFile) string {
+	const (
+		maxTags        = 10
+		maxTagKeyLen   = 128
+		maxTagValueLen = 256
+	)
+	attrs := sessionAttributes(createdFile)
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	// Session attributes go first, then labels sorted by name
+	sort.Slice(keys, func(i, j int) bool {
+		li, lj := strings.HasPrefix(keys[i], labelPrefix), strings.HasPrefix(keys[j], labelPrefix)
+		if li != lj {
+			return lj
 		}
-		fileExcluded = fe
+		return keys[i] < keys[j]
+	})
+	tags := url.Values{}
+	for _, k := range keys {
+		if len(tags) == maxTags {
+			break
+		}
+		tags.Set(truncate(tagText(k), maxTagKeyLen), truncate(tagText(attrs[k]), maxTagValueLen))
 	}
-	return fileIncluded && !fileExcluded, nil
+	return tags.Encode()
 }
 
-func GetS3Key(keyPattern string, createdFile event.CreatedFile) string {
+const labelPrefix = "label-"
+
+func sessionAttributes(createdFile event.CreatedFile) map[string]string {
 	sess := createdFile.Session
-	pt := keyPattern
-	if sess.Caps.S3KeyPattern != "" {
-		pt = sess.Caps.S3KeyPattern
+	ret := map[string]string{
+		"session-id": createdFile.SessionId,
+		"file-type":  createdFile.Type,
+	}
+	add := func(k string, v string) {
+		if v != "" {
+			ret[k] = v
+		}
+	}
+	add("quota", sess.Quota)
+	add("browser-name", sess.Caps.BrowserName())
+	add("browser-version", sess.Caps.Version)
+	add("test-name", sess.Caps.TestName)
+	for k, v := range sess.Caps.Labels {
+		add(labelPrefix+k, v)
+	}
+	return ret
+}
+
+func metadataKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_') {
+			return r
+		}
+		return '-'
+	}, k)
+}
+
+func tagText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || strings.ContainsRune("+-=._:/@", r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
 	}
-	filename := createdFile.Name
-	key := strings.Replace(pt, "$fileName", filepath.Base(filename), -1)
-	key = strings.Replace(key, "$fileExtension", strings.ToLower(filepath.Ext(filename)), -1)
-	key = strings.Replace(key, "$browserName", strings.ToLower(sess.Caps.BrowserName()), -1)
-	key = strings.Replace(key, "$browserVersion", strings.ToLower(sess.Caps.Version), -1)
-	key = strings.Replace(key, "$platformName", strings.ToLower(sess.Caps.Platform), -1)
-	key = strings.Replace(key, "$quota", strings.ToLower(sess.Quota), -1)
-	key = strings.Replace(key, "$sessionId", createdFile.SessionId, -1)
-	key = strings.Replace(key, "$fileType", strings.ToLower(createdFile.Type), -1)
-	key = strings.Replace(key, "$date", time.Now().Format("2006-01-02"), -1)
-	key = strings.Replace(key, " ", "-", -1)
-	return key
+	return string([]rune(s)[:n])
 }
@@ -0,0 +1,218 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+)
+
+var targetTypes = map[string]func() Uploader{
+	"archive": func() Uploader {
+		return &ArchiveUploader{PathPattern: "$quota/$date/$fileName"}
+	},
+	"http": func() Uploader {
+		return &HTTPUploader{KeyPattern: "$fileName", Timeout: Duration(10 * time.Minute)}
+	},
+}
+
+// AddTargetType - register uploader type that can be used in upload targets configuration file
+func AddTargetType(name string, factory func() Uploader) {
+	targetTypes[name] = factory
+}
+
+// Duration - duration in target settings written in time.Duration format like command-line flags, e.g. 30s
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration should be a string like 30s: %s", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Match - upload target routing rules, every non-empty field should match uploaded file
+type Match struct {
+	Types        []string          `json:"types,omitempty"`
+	Quotas       []string          `json:"quotas,omitempty"`
+	Browsers     []string          `json:"browsers,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Files        []string          `json:"files,omitempty"`
+	ExcludeFiles []string          `json:"excludeFiles,omitempty"`
+}
+
+// Target - upload destination with its own uploader settings and routing rules
+type Target struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	Match    Match           `json:"match"`
+	Settings json.RawMessage `json:"settings"`
+}
+
+type routedUploader struct {
+	Uploader
+	name  string
+	match Match
+}
+
+func (r *routedUploader) Upload(createdFile event.CreatedFile) (bool, error) {
+	_, uploaded, err := r.UploadTo(createdFile)
+	return uploaded, err
+}
+
+func (r *routedUploader) UploadTo(createdFile event.CreatedFile) (string, bool, error) {
+	if !r.match.matches(createdFile) {
+		log.Printf("[%d] [SKIPPING_FILE] [%s] [Does not match upload target %s]", createdFile.RequestId, createdFile.Name, r.name)
+		return "", false, nil
+	}
+	return uploadTo(r.Uploader, createdFile)
+}
+
+// KeepsFiles - file is removed by upload queue only after uploads to all matching targets succeed
+func (r *routedUploader) KeepsFiles() bool {
+	return keepsFiles(r.Uploader)
+}
+
+func (r *routedUploader) Link(location string, expires time.Duration) (string, bool, error) {
+	if l, ok := r.Uploader.(Locator); ok {
+		return l.Link(location, expires)
+	}
+	return "", false, nil
+}
+
+// LoadTargets - create uploaders for every target from configuration file
+func LoadTargets(filename string) ([]Uploader, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("upload config: read error: %v", err)
+	}
+	var targets []Target
+	if err := decodeStrict(buf, &targets); err != nil {
+		return nil, fmt.Errorf("upload config: parse error: %v", err)
+	}
+	var ret []Uploader
+	for i, t := range targets {
+		name := t.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		u, err := t.uploader()
+		if err != nil {
+			return nil, fmt.Errorf("upload config: target %s: %v", name, err)
+		}
+		ret = append(ret, &routedUploader{Uploader: u, name: name, match: t.Match})
+	}
+	log.Printf("[-] [INIT] [Loaded %d upload targets from %s]", len(ret), filename)
+	return ret, nil
+}
+
+func (t *Target) uploader() (Uploader, error) {
+	factory, ok := targetTypes[t.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported type %q", t.Type)
+	}
+	if err := t.Match.validate(); err != nil {
+		return nil, err
+	}
+	u := factory()
+	if len(t.Settings) > 0 {
+		if err := decodeStrict(t.Settings, u); err != nil {
+			return nil, fmt.Errorf("invalid settings: %v", err)
+		}
+	}
+	event.InitIfNeeded(u)
+	if c, ok := u.(Configurable); ok && !c.Configured() {
+		return nil, fmt.Errorf("incomplete %s settings", t.Type)
+	}
+	return u, nil
+}
+
+// decodeStrict - unknown keys are rejected so that misspelled settings are not silently ignored
+func decodeStrict(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+	if d.More() {
+		return errors.New("unexpected data after JSON value")
+	}
+	return nil
+}
+
+func (m *Match) validate() error {
+	var patterns []string
+	patterns = append(patterns, m.Types...)
+	patterns = append(patterns, m.Quotas...)
+	patterns = append(patterns, m.Browsers...)
+	patterns = append(patterns, m.Files...)
+	patterns = append(patterns, m.ExcludeFiles...)
+	for _, v := range m.Labels {
+		patterns = append(patterns, v)
+	}
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %v", p, err)
+		}
+	}
+	return nil
+}
+
+func (m *Match) matches(createdFile event.CreatedFile) bool {
+	sess := createdFile.Session
+	name := filepath.Base(createdFile.Name)
+	if !matchesAny(m.Types, createdFile.Type) ||
+		!matchesAny(m.Quotas, sess.Quota) ||
+		!matchesAny(m.Browsers, sess.Caps.BrowserName()) ||
+		!matchesAny(m.Files, name) {
+		return false
+	}
+	if len(m.ExcludeFiles) > 0 && matchesAny(m.ExcludeFiles, name) {
+		return false
+	}
+	for k, pattern := range m.Labels {
+		v, ok := sess.Caps.Labels[k]
+		if !ok {
+			return false
+		}
+		if pattern == "" {
+			continue
+		}
+		if matched, _ := path.Match(pattern, v); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, value); ok {
+			return true
+		}
+	}
+	return false
+}
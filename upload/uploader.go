@@ -1,7 +1,7 @@
 package upload
 
 import (
-	"github.com/aerokube/selenoid/info"
+	"flag"
 	"log"
 	"time"
 
@@ -10,21 +10,96 @@ import (
 
 var (
 	upl *Upload
+
+	spoolDir    string
+	targetsConf string
+	workers     int
+	retries     int
+	retryDelay  time.Duration
+	linkExpiry  time.Duration
 )
 
+func init() {
+	flag.StringVar(&targetsConf, "upload-conf", "", "Upload targets configuration file")
+	flag.StringVar(&spoolDir, "upload-spool-dir", "", "Directory to persist pending uploads to")
+	flag.IntVar(&workers, "upload-workers", 4, "Number of simultaneous uploads")
+	flag.IntVar(&retries, "upload-retries", 5, "Number of failed upload retries")
+	flag.DurationVar(&retryDelay, "upload-retry-delay", 10*time.Second, "Delay before first failed upload retry in time.Duration format, doubled for every next retry")
+	flag.DurationVar(&linkExpiry, "upload-link-expiry", time.Hour, "Expiration time of links to uploaded files in time.Duration format")
+}
+
 type Uploader interface {
 	Upload(createdFile event.CreatedFile) (bool, error)
 }
 
+// Configurable - uploaders implementing this interface are only used when configured
+type Configurable interface {
+	Configured() bool
+}
+
+// Locator - uploaders implementing this interface remember where files were uploaded
+type Locator interface {
+	// UploadTo - upload file and return its location, e.g. s3://bucket/key
+	UploadTo(createdFile event.CreatedFile) (string, bool, error)
+	// Link - URL to download file from, returns false when location does not belong to this uploader
+	Link(location string, expires time.Duration) (string, bool, error)
+}
+
+// Keeper - uploaders implementing this interface let upload queue remove local files once all uploads of a file succeed
+type Keeper interface {
+	// KeepsFiles - whether uploaded local file should be kept
+	KeepsFiles() bool
+}
+
+// keepsFiles - files uploaded by uploaders not implementing Keeper are never removed
+func keepsFiles(u Uploader) bool {
+	k, ok := u.(Keeper)
+	return !ok || k.KeepsFiles()
+}
+
+func uploadTo(u Uploader, createdFile event.CreatedFile) (string, bool, error) {
+	if l, ok := u.(Locator); ok {
+		return l.UploadTo(createdFile)
+	}
+	uploaded, err := u.Upload(createdFile)
+	return "", uploaded, err
+}
+
 type Upload struct {
 	uploaders []Uploader
+	queue     *Queue
 }
 
 func Init() {
+	targets, err := LoadTargets(targetsConf)
+	if err != nil {
+		log.Fatalf("[-] [INIT] [%v]", err)
+	}
+	for _, t := range targets {
+		AddUploader(t)
+	}
 	if upl != nil {
-		for _, upl := range upl.uploaders {
-			event.InitIfNeeded(upl)
+		var uploaders []Uploader
+		for _, u := range upl.uploaders {
+			event.InitIfNeeded(u)
+			if c, ok := u.(Configurable); ok && !c.Configured() {
+				continue
+			}
+			uploaders = append(uploaders, u)
 		}
+		if len(uploaders) == 0 {
+			return
+		}
+		queue, err := NewQueue(uploaders, spoolDir, workers, retries, retryDelay)
+		if err != nil {
+			log.Fatalf("[-] [INIT] [Failed to initialize upload queue: %v]", err)
+		}
+		queue.catalog, err = NewCatalog(spoolDir, CatalogSessions)
+		if err != nil {
+			log.Fatalf("[-] [INIT] [Failed to initialize upload queue: %v]", err)
+		}
+		queue.Start()
+		upl.queue = queue
 	}
 }
 
@@ -37,19 +112,82 @@ func AddUploader(u Uploader) {
 }
 
 func (ul *Upload) OnFileCreated(createdFile event.CreatedFile) {
-	if len(ul.uploaders) > 0 {
-		for _, uploader := range ul.uploaders {
-			go func(uploader Uploader) {
-				s := time.Now()
-				uploaded, err := uploader.Upload(createdFile)
-				if err != nil {
-					log.Printf("[%d] [UPLOADING_FILE] [%s] [Failed to upload: %v]", createdFile.RequestId, createdFile.Name, err)
-					return
-				}
-				if uploaded {
-					log.Printf("[%d] [UPLOADED_FILE] [%s] [%.2fs]", createdFile.RequestId, createdFile.Name, info.SecondsSince(s))
-				}
-			}(uploader)
+	if ul.queue != nil {
+		ul.queue.Add(createdFile)
+	}
+}
+
+// QueueStatus - get upload queue status, returns false when no uploaders are configured
+func QueueStatus() (Status, bool) {
+	if upl == nil || upl.queue == nil {
+		return Status{}, false
+	}
+	return upl.queue.Status(), true
+}
+
+// Retry - retry upload task right now
+func Retry(id string) bool {
+	if upl == nil || upl.queue == nil {
+		return false
+	}
+	return upl.queue.Retry(id)
+}
+
+// Stop - finish pending uploads
+func Stop() {
+	if upl != nil && upl.queue != nil {
+		upl.queue.Stop()
+	}
+}
+
+// Artifacts - uploaded files of given types
+func Artifacts(types ...string) []Artifact {
+	if upl == nil || upl.queue == nil {
+		return nil
+	}
+	return upl.queue.catalog.List(types...)
+}
+
+// Link - URL to download uploaded file from, file:// URLs point to files in local archive
+func Link(name string, types ...string) (string, bool, error) {
+	if upl == nil || upl.queue == nil {
+		return "", false, nil
+	}
+	var lastErr error
+	for _, a := range upl.queue.catalog.Get(name) {
+		if len(types) > 0 && !contains(types, a.Type) {
+			continue
 		}
+		for _, u := range upl.queue.uploaders {
+			l, ok := u.(Locator)
+			if !ok {
+				continue
+			}
+			link, ok, err := l.Link(a.Location, linkExpiry)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if ok {
+				return link, true, nil
+			}
+		}
+	}
+	return "", false, lastErr
+}
+
+// Uploaded - whether file was uploaded somewhere
+func Uploaded(name string) bool {
+	if upl == nil || upl.queue == nil {
+		return false
+	}
+	return len(upl.queue.catalog.Get(name)) > 0
+}
+
+// Forget - forget locations of uploaded file, uploaded copies are not removed
+func Forget(name string) bool {
+	if upl == nil || upl.queue == nil {
+		return false
 	}
+	return upl.queue.catalog.Forget(name)
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+	"github.com/aerokube/selenoid/upload"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestUploadTargets(t *testing.T) {
+	dir, err := os.MkdirTemp("", "selenoid-targets")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	videos, logs := filepath.Join(dir, "videos"), filepath.Join(dir, "logs")
+	conf := filepath.Join(dir, "upload.json")
+	assert.NoError(t, os.WriteFile(conf, []byte(fmt.Sprintf(`[
+		{
+			"name": "cold-videos",
+			"type": "archive",
+			"match": {"types": ["video"], "quotas": ["some-*"], "labels": {"team": "qa"}},
+			"settings": {"dir": %q, "pathPattern": "$sessionId$fileExtension", "keepFiles": true}
+		},
+		{
+			"name": "logs",
+			"type": "archive",
+			"match": {"types": ["log"], "browsers": ["chrome", "firefox"], "excludeFiles": ["*.tmp"]},
+			"settings": {"dir": %q, "keepFiles": true}
+		}
+	]`, videos, logs)), 0644))
+
+	targets, err := upload.LoadTargets(conf)
+	assert.NoError(t, err)
+	assert.Len(t, targets, 2)
+
+	f, _ := os.CreateTemp("", "some-file*.mp4")
+	_ = f.Close()
+	defer os.Remove(f.Name())
+	video := testCreatedFile(f.Name())
+	video.Session.Caps.Labels = map[string]string{"team": "qa"}
+	log := testCreatedFile(f.Name())
+	log.Type = "log"
+
+	for _, tc := range []struct {
+		target   int
+		file     event.CreatedFile
+		uploaded bool
+	}{
+		{0, video, true},
+		{1, video, false},
+		{0, log, false},
+		{1, log, true},
+	} {
+		uploaded, err := targets[tc.target].Upload(tc.file)
+		assert.NoError(t, err)
+		assert.Equal(t, uploaded, tc.uploaded)
+	}
+
+	_, err = os.Stat(filepath.Join(videos, "some-session-id.mp4"))
+	assert.NoError(t, err)
+	files, err := os.ReadDir(filepath.Join(logs, "some-user"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	video.Session.Caps.Labels = map[string]string{"team": "dev"}
+	uploaded, err := targets[0].Upload(video)
+	assert.NoError(t, err)
+	assert.False(t, uploaded)
+}
+
+func TestUploadTargetsRemoveFileAfterAllTargets(t *testing.T) {
+	dir, err := os.MkdirTemp("", "selenoid-targets")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	primary, backup := filepath.Join(dir, "primary"), filepath.Join(dir, "backup")
+	conf := filepath.Join(dir, "upload.json")
+	assert.NoError(t, os.WriteFile(conf, []byte(fmt.Sprintf(`[
+		{"name": "primary", "type": "archive", "match": {"types": ["video"]}, "settings": {"dir": %q, "pathPattern": "$fileName"}},
+		{"name": "logs", "type": "archive", "match": {"types": ["log"]}, "settings": {"dir": %q, "pathPattern": "$fileName"}},
+		{"name": "backup", "type": "archive", "match": {"types": ["video"]}, "settings": {"dir": %q, "pathPattern": "$fileName"}}
+	]`, primary, primary, backup)), 0644))
+	targets, err := upload.LoadTargets(conf)
+	assert.NoError(t, err)
+	q, err := upload.NewQueue(targets, "", 1, 3, 10*time.Millisecond)
+	assert.NoError(t, err)
+	q.Start()
+	defer q.Stop()
+
+	f, _ := os.CreateTemp("", "some-file*.mp4")
+	_ = f.Close()
+	defer os.Remove(f.Name())
+	q.Add(testCreatedFile(f.Name()))
+	waitFor(t, func() bool {
+		_, err := os.Stat(f.Name())
+		return os.IsNotExist(err)
+	})
+	assert.Len(t, q.Status().Completed, 2)
+	for _, d := range []string{primary, backup} {
+		_, err := os.Stat(filepath.Join(d, filepath.Base(f.Name())))
+		assert.NoError(t, err)
+	}
+}
+
+func TestUploadTargetsHTTPTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+	f, _ := os.CreateTemp("", "upload*.json")
+	_, _ = f.WriteString(fmt.Sprintf(`[{"type": "http", "settings": {"url": %q, "timeout": "50ms", "keepFiles": true, "headers": {"X-Team": ["qa"]}}}]`, srv.URL))
+	_ = f.Close()
+	defer os.Remove(f.Name())
+
+	targets, err := upload.LoadTargets(f.Name())
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1)
+
+	file, _ := os.CreateTemp("", "some-file*.log")
+	_ = file.Close()
+	defer os.Remove(file.Name())
+	_, err = targets[0].Upload(testCreatedFile(file.Name()))
+	assert.ErrorContains(t, err, "Client.Timeout exceeded")
+}
+
+func TestUploadTargetsErrors(t *testing.T) {
+	targets, err := upload.LoadTargets("")
+	assert.NoError(t, err)
+	assert.Empty(t, targets)
+
+	for _, conf := range []string{
+		`[{"type": "unknown"}]`,
+		`[{"type": "http", "settings": {}}]`,
+		`[{"type": "http", "settings": {"url": "http://127.0.0.1/", "timeout": 30}}]`,
+		`[{"type": "http", "settings": {"url": "http://127.0.0.1/", "timeout": "30 seconds"}}]`,
+		`[{"type": "archive", "match": {"quotas": ["["]}, "settings": {"dir": "/tmp"}}]`,
+		`{}`,
+		`[{"type": "archive", "settings": {"dir": "/tmp", "path-pattern": "$fileName"}}]`,
+		`[{"type": "archive", "match": {"type": ["video"]}, "settings": {"dir": "/tmp"}}]`,
+		`[{"type": "archive", "setting": {"dir": "/tmp"}}]`,
+	} {
+		f, _ := os.CreateTemp("", "upload*.json")
+		_, _ = f.WriteString(conf)
+		_ = f.Close()
+		_, err := upload.LoadTargets(f.Name())
+		os.Remove(f.Name())
+		assert.Error(t, err, conf)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aerokube/selenoid/event"
+	"github.com/aerokube/selenoid/session"
+	"github.com/aerokube/selenoid/upload"
+	assert "github.com/stretchr/testify/require"
+)
+
+type flakyUploader struct {
+	lock     sync.Mutex
+	failures int
+	uploaded []string
+}
+
+func (u *flakyUploader) Upload(createdFile event.CreatedFile) (bool, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.failures > 0 {
+		u.failures--
+		return false, errors.New("storage is not available")
+	}
+	u.uploaded = append(u.uploaded, createdFile.Name)
+	return true, nil
+}
+
+func (u *flakyUploader) Uploaded() []string {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return append([]string{}, u.uploaded...)
+}
+
+// removingUploader - lets upload queue remove uploaded files
+type removingUploader struct {
+	flakyUploader
+}
+
+func (u *removingUploader) KeepsFiles() bool {
+	return false
+}
+
+func testCreatedFile(name string) event.CreatedFile {
+	return event.CreatedFile{
+		Event: event.Event{
+			RequestId: 42,
+			SessionId: "some-session-id",
+			Session: &session.Session{
+				Quota: "some-user",
+				Caps:  session.Caps{Name: "firefox", Version: "57.0"},
+			},
+		},
+		Name: name,
+		Type: "video",
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			assert.FailNow(t, "condition not met in time")
+		}
+		<-time.After(5 * time.Millisecond)
+	}
+}
+
+func TestUploadQueueRetries(t *testing.T) {
+	uploader := &flakyUploader{failures: 2}
+	q, err := upload.NewQueue([]upload.Uploader{uploader}, "", 2, 3, 10*time.Millisecond)
+	assert.NoError(t, err)
+	q.Start()
+	defer q.Stop()
+
+	q.Add(testCreatedFile("/path/to/video.mp4"))
+	waitFor(t, func() bool {
+		return len(uploader.Uploaded()) == 1
+	})
+	status := q.Status()
+	assert.Empty(t, status.Pending)
+	assert.Empty(t, status.Failed)
+	assert.Len(t, status.Completed, 1)
+	assert.Equal(t, status.Completed[0].Attempts, 3)
+}
+
+func TestUploadQueueFailedAndRetried(t *testing.T) {
+	uploader := &flakyUploader{failures: 2}
+	q, err := upload.NewQueue([]upload.Uploader{uploader}, "", 1, 1, 10*time.Millisecond)
+	assert.NoError(t, err)
+	q.Start()
+	defer q.Stop()
+
+	q.Add(testCreatedFile("/path/to/video.mp4"))
+	waitFor(t, func() bool {
+		return len(q.Status().Failed) == 1
+	})
+	failed := q.Status().Failed[0]
+	assert.Equal(t, failed.LastError, "storage is not available")
+
+	assert.True(t, q.Retry(failed.ID))
+	assert.False(t, q.Retry("missing-id"))
+	waitFor(t, func() bool {
+		return len(uploader.Uploaded()) == 1
+	})
+}
+
+func TestUploadQueueSpoolDir(t *testing.T) {
+	dir, err := os.MkdirTemp("", "selenoid-spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	uploader := &flakyUploader{failures: 1}
+	q, err := upload.NewQueue([]upload.Uploader{uploader}, dir, 1, 5, time.Hour)
+	assert.NoError(t, err)
+	q.Start()
+	q.Add(testCreatedFile("/path/to/video.mp4"))
+	waitFor(t, func() bool {
+		status := q.Status()
+		return len(status.Pending) == 1 && status.Pending[0].Attempts == 1 && status.Pending[0].State == upload.TaskPending
+	})
+	q.Stop()
+
+	q, err = upload.NewQueue([]upload.Uploader{uploader}, dir, 1, 5, time.Hour)
+	assert.NoError(t, err)
+	assert.Len(t, q.Status().Pending, 1)
+	q.Start()
+	defer q.Stop()
+	waitFor(t, func() bool {
+		return len(uploader.Uploaded()) == 1
+	})
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestUploadQueueRemovesFileAfterAllUploads(t *testing.T) {
+	archive, dir := testArchiveUploader(t, false)
+	defer os.RemoveAll(dir)
+	uploader := &removingUploader{flakyUploader{failures: 1}}
+	q, err := upload.NewQueue([]upload.Uploader{archive, uploader}, "", 2, 3, 50*time.Millisecond)
+	assert.NoError(t, err)
+	q.Start()
+	defer q.Stop()
+
+	f, _ := os.CreateTemp("", "some-file*.mp4")
+	_ = f.Close()
+	defer os.Remove(f.Name())
+	q.Add(testCreatedFile(f.Name()))
+	waitFor(t, func() bool {
+		return len(q.Status().Completed) == 1
+	})
+	_, err = os.Stat(f.Name())
+	assert.NoError(t, err)
+	waitFor(t, func() bool {
+		return len(uploader.Uploaded()) == 1
+	})
+	waitFor(t, func() bool {
+		_, err := os.Stat(f.Name())
+		return os.IsNotExist(err)
+	})
+}
+
+func TestUploadQueueKeepsFile(t *testing.T) {
+	archive, dir := testArchiveUploader(t, true)
+	defer os.RemoveAll(dir)
+	uploader := &removingUploader{}
+	q, err := upload.NewQueue([]upload.Uploader{uploader, archive}, "", 1, 3, 10*time.Millisecond)
+	assert.NoError(t, err)
+	q.Start()
+	defer q.Stop()
+
+	f, _ := os.CreateTemp("", "some-file*.mp4")
+	_ = f.Close()
+	defer os.Remove(f.Name())
+	q.Add(testCreatedFile(f.Name()))
+	waitFor(t, func() bool {
+		return len(q.Status().Completed) == 2
+	})
+	_, err = os.Stat(f.Name())
+	assert.NoError(t, err)
+}
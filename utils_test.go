@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
+	"image/png"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -127,6 +131,16 @@ func Selenium(nsp ...func(map[string]interface{})) http.Handler {
 			http.Error(w, "Session not found", http.StatusNotFound)
 			return
 		}
+		if strings.HasSuffix(r.URL.Path, "/screenshot") {
+			var buf bytes.Buffer
+			_ = png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)))
+			_ = json.NewEncoder(w).Encode(map[string]string{"value": base64.StdEncoding.EncodeToString(buf.Bytes())})
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/source") {
+			_ = json.NewEncoder(w).Encode(map[string]string{"value": "<html></html>"})
+			return
+		}
 		if r.FormValue("abort-handler") != "" {
 			out := "this call was relayed by the reverse proxy"
 			// Setting wrong Content-Length leads to abort handler error
@@ -182,6 +196,10 @@ func Selenium(nsp ...func(map[string]interface{})) http.Handler {
 				}
 			}
 		}
+		if _, ok := r.URL.Query()["json"]; ok {
+			_ = json.NewEncoder(w).Encode([]string{"testfile"})
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("test-clipboard-value"))
 	})
@@ -258,6 +276,40 @@ func TestSumUsedTotalGreaterThanPending(t *testing.T) {
 	assert.Equal(t, queue.Used(), 2)
 }
 
+func TestDrainRejectsQueuedRequests(t *testing.T) {
+	queue := protect.New(1, false)
+	handled := 0
+	hf := func(_ http.ResponseWriter, _ *http.Request) {
+		handled++
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", queue.Try(queue.Check(queue.Protect(hf))))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	u := srv.URL + "/"
+
+	_, err := http.Get(u)
+	assert.NoError(t, err)
+	queue.Create()
+
+	queued := make(chan *http.Response)
+	go func() {
+		rsp, _ := http.Get(u)
+		queued <- rsp
+	}()
+	waitFor(t, func() bool {
+		return queue.Queued() == 1
+	})
+	queue.Drain(true)
+	queue.Release()
+	rsp := <-queued
+	assert.NotNil(t, rsp)
+	assert.Equal(t, rsp.StatusCode, http.StatusTooManyRequests)
+	assert.Equal(t, handled, 1)
+	assert.Equal(t, queue.Pending(), 0)
+	assert.Equal(t, queue.Used(), 0)
+}
+
 func TestBrowserName(t *testing.T) {
 	var caps session.Caps
 
@@ -284,3 +336,48 @@ func TestBrowserName(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, caps.BrowserName(), "firefox")
 }
+
+// devtoolsStub - answers all CDP commands and starts sending events after given command
+func devtoolsStub(method string, events <-chan string) http.Handler {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(_ *http.Request) bool {
+			return true
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lock sync.Mutex
+		done := make(chan struct{})
+		defer close(done)
+		for {
+			var msg struct {
+				ID     int    `json:"id"`
+				Method string `json:"method"`
+			}
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			lock.Lock()
+			_ = conn.WriteJSON(map[string]interface{}{"id": msg.ID, "result": map[string]interface{}{}})
+			lock.Unlock()
+			if msg.Method == method {
+				go func() {
+					for {
+						select {
+						case <-done:
+							return
+						case e := <-events:
+							lock.Lock()
+							_ = conn.WriteMessage(websocket.TextMessage, []byte(e))
+							lock.Unlock()
+						}
+					}
+				}()
+			}
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/aerokube/selenoid/rfb"
+)
+
+const viewOnlyParam = "view-only"
+
+// Pseudo-encodings making server accept extension client messages, view-only clients are not allowed to send them
+var viewOnlyStrippedEncodings = map[int32]bool{
+	-258:        true, // QEMU Extended Key Event
+	-261:        true, // QEMU Audio
+	-308:        true, // ExtendedDesktopSize enabling SetDesktopSize
+	-309:        true, // xvp
+	-312:        true, // Fence
+	-313:        true, // ContinuousUpdates enabling EnableContinuousUpdates
+	-0x3F5E1A32: true, // Extended Clipboard (0xC0A1E5CE)
+}
+
+// viewOnlyEncodings - removes pseudo-encodings of extension client messages from SetEncodings message without type
+func viewOnlyEncodings(message []byte) []byte {
+	ret := message[:3:3]
+	for i := 3; i+4 <= len(message); i += 4 {
+		if !viewOnlyStrippedEncodings[int32(binary.BigEndian.Uint32(message[i:]))] {
+			ret = append(ret, message[i:i+4]...)
+		}
+	}
+	binary.BigEndian.PutUint16(ret[1:], uint16((len(ret)-3)/4))
+	return ret
+}
+
+// copyViewOnly - copies RFB client stream to server dropping keyboard, mouse and clipboard messages
+func copyViewOnly(dst io.Writer, src io.Reader) error {
+	r := bufio.NewReader(src)
+	read := func(n int) ([]byte, error) {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+	forward := func(n int) ([]byte, error) {
+		buf, err := read(n)
+		if err != nil {
+			return nil, err
+		}
+		_, err = dst.Write(buf)
+		return buf, err
+	}
+	version, err := forward(12)
+	if err != nil {
+		return err
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(string(version), "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 || minor < 7 {
+		return fmt.Errorf("unsupported protocol version %q", version)
+	}
+	security, err := forward(1)
+	if err != nil {
+		return err
+	}
+	switch security[0] {
+	case rfb.SecurityNone:
+	case rfb.SecurityVNC:
+		if _, err := forward(16); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported security type %d", security[0])
+	}
+	// ClientInit
+	if _, err := forward(1); err != nil {
+		return err
+	}
+	for {
+		messageType, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		var message []byte
+		drop := false
+		switch messageType {
+		case rfb.SetPixelFormat:
+			message, err = read(19)
+		case rfb.SetEncodings:
+			message, err = read(3)
+			if err == nil {
+				var encodings []byte
+				encodings, err = read(4 * int(binary.BigEndian.Uint16(message[1:])))
+				message = viewOnlyEncodings(append(message, encodings...))
+			}
+		case rfb.FramebufferUpdateRequest:
+			message, err = read(9)
+		case rfb.KeyEvent:
+			drop = true
+			_, err = read(7)
+		case rfb.PointerEvent:
+			drop = true
+			_, err = read(5)
+		case rfb.ClientCutText:
+			drop = true
+			message, err = read(7)
+			if err == nil {
+				_, err = io.CopyN(io.Discard, r, int64(binary.BigEndian.Uint32(message[3:])))
+			}
+		default:
+			return fmt.Errorf("unsupported message type %d", messageType)
+		}
+		if err != nil {
+			return err
+		}
+		if drop {
+			continue
+		}
+		if _, err := dst.Write(append([]byte{messageType}, message...)); err != nil {
+			return err
+		}
+	}
+}
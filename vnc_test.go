@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aerokube/selenoid/policy"
+	"github.com/aerokube/selenoid/rfb"
+	assert "github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+var (
+	rfbHandshake         = append(append([]byte("RFB 003.008\n"), rfb.SecurityVNC), append(bytes.Repeat([]byte{0xAB}, 16), 1)...)
+	rfbSetPixelFormatMsg = append([]byte{rfb.SetPixelFormat, 0, 0, 0}, bytes.Repeat([]byte{0x10}, 16)...)
+	rfbSetEncodingsMsg   = []byte{rfb.SetEncodings, 0, 0, 2, 0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0x21}
+	rfbUpdateRequestMsg  = []byte{rfb.FramebufferUpdateRequest, 1, 0, 0, 0, 0, 0x04, 0, 0x03, 0}
+	rfbKeyEventMsg       = []byte{rfb.KeyEvent, 1, 0, 0, 0, 0, 0, 0x61}
+	rfbPointerEventMsg   = []byte{rfb.PointerEvent, 1, 0, 10, 0, 20}
+	rfbClientCutTextMsg  = append([]byte{rfb.ClientCutText, 0, 0, 0, 0, 0, 0, 5}, "hello"...)
+)
+
+func rfbStream(messages ...[]byte) []byte {
+	return bytes.Join(append([][]byte{rfbHandshake}, messages...), nil)
+}
+
+func TestCopyViewOnly(t *testing.T) {
+	var out bytes.Buffer
+	in := rfbStream(rfbSetPixelFormatMsg, rfbKeyEventMsg, rfbSetEncodingsMsg, rfbPointerEventMsg, rfbClientCutTextMsg, rfbUpdateRequestMsg)
+	assert.Equal(t, copyViewOnly(&out, bytes.NewReader(in)), io.EOF)
+	assert.Equal(t, out.Bytes(), rfbStream(rfbSetPixelFormatMsg, rfbSetEncodingsMsg, rfbUpdateRequestMsg))
+}
+
+func TestCopyViewOnlyStripsExtensionEncodings(t *testing.T) {
+	// Encodings sent by noVNC
+	encodings := []int32{7, 16, 1, 5, 2, 0, -314, -316, -224, -223, -239, -258, -261, -307, -308, -309, -312, -313, -247, -0x3F5E1A32}
+	msg := []byte{rfb.SetEncodings, 0, 0, byte(len(encodings))}
+	for _, e := range encodings {
+		msg = binary.BigEndian.AppendUint32(msg, uint32(e))
+	}
+	var out bytes.Buffer
+	assert.Equal(t, copyViewOnly(&out, bytes.NewReader(rfbStream(msg, rfbUpdateRequestMsg))), io.EOF)
+
+	expected := []byte{rfb.SetEncodings, 0, 0, 13}
+	for _, e := range []int32{7, 16, 1, 5, 2, 0, -314, -316, -224, -223, -239, -307, -247} {
+		expected = binary.BigEndian.AppendUint32(expected, uint32(e))
+	}
+	assert.Equal(t, out.Bytes(), rfbStream(expected, rfbUpdateRequestMsg))
+}
+
+func TestCopyViewOnlyUnsupported(t *testing.T) {
+	assert.ErrorContains(t, copyViewOnly(io.Discard, strings.NewReader("RFB 003.003\n")), "unsupported protocol version")
+	assert.ErrorContains(t, copyViewOnly(io.Discard, strings.NewReader("RFB 003.008\n\x10")), "unsupported security type 16")
+	assert.ErrorContains(t, copyViewOnly(io.Discard, bytes.NewReader(rfbStream([]byte{250, 0}))), "unsupported message type 250")
+}
+
+// receivedVNCMessages - client messages received by VNC server of session created with capabilities when VNC is opened with query
+func receivedVNCMessages(t *testing.T, caps string, query string) []byte {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	received := make(chan []byte)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+
+	manager = &HTTPTest{Handler: Selenium()}
+	resp, err := http.Post(With(srv.URL).Path("/wd/hub/session"), "", strings.NewReader(caps))
+	assert.NoError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var body map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	sid := body["sessionId"]
+	defer func() {
+		sessions.Remove(sid)
+		queue.Release()
+	}()
+	sess, ok := sessions.Get(sid)
+	assert.True(t, ok)
+	sess.HostPort.VNC = l.Addr().String()
+
+	ws, err := websocket.Dial("ws://"+srv.Listener.Addr().String()+paths.VNC+sid+query, "", "http://localhost/")
+	assert.NoError(t, err)
+	ws.PayloadType = websocket.BinaryFrame
+	_, err = ws.Write(rfbStream(rfbSetPixelFormatMsg, rfbPointerEventMsg))
+	assert.NoError(t, err)
+	_, err = ws.Write(rfbKeyEventMsg)
+	assert.NoError(t, err)
+	_, err = ws.Write(rfbUpdateRequestMsg)
+	assert.NoError(t, err)
+	assert.NoError(t, ws.Close())
+	return <-received
+}
+
+func TestVNCViewOnly(t *testing.T) {
+	assert.Equal(t, receivedVNCMessages(t, "{}", "?"+viewOnlyParam), rfbStream(rfbSetPixelFormatMsg, rfbUpdateRequestMsg))
+}
+
+func TestVNCViewOnlyPolicy(t *testing.T) {
+	fullControl := `{"desiredCapabilities": {"vncViewOnly": false}}`
+	assert.Equal(t, receivedVNCMessages(t, fullControl, ""), rfbStream(rfbSetPixelFormatMsg, rfbPointerEventMsg, rfbKeyEventMsg, rfbUpdateRequestMsg))
+
+	// Only policy set rule enforces read-only mode, query parameter and capability are chosen by client
+	policies = loadTestPolicies(t, `[{"set": {"vncViewOnly": true}}]`)
+	defer func() {
+		policies = policy.New()
+	}()
+	assert.Equal(t, receivedVNCMessages(t, fullControl, ""), rfbStream(rfbSetPixelFormatMsg, rfbUpdateRequestMsg))
+}